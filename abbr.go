@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/hex"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// abbrMarker starts the comment an abbreviation definition is parsed as,
+// carrying the hex encoded abbreviation and its expansion.
+const abbrMarker = "<!--mdview-abbr "
+
+func init() {
+	markdown.RegisterBlockRule(680, ruleAbbreviation, []int{1100, 700})
+}
+
+// ruleAbbreviation parses abbreviation definitions in the syntax of PHP
+// Markdown Extra,
+//
+//	*[HTML]: HyperText Markup Language
+//
+// as marker comments for the abbreviations pass.
+func ruleAbbreviation(s *markdown.StateBlock, startLine, endLine int, silent bool) bool {
+	if !enabled(s.Md, extAbbreviations) || s.SCount[startLine]-s.BlkIndent >= 4 {
+		return false
+	}
+	line := s.Src[s.BMarks[startLine]+s.TShift[startLine] : s.EMarks[startLine]]
+	if !strings.HasPrefix(line, "*[") {
+		return false
+	}
+	end := strings.Index(line, "]:")
+	if end < 0 {
+		return false
+	}
+	abbr := line[2:end]
+	title := strings.TrimSpace(line[end+2:])
+	if strings.TrimSpace(abbr) == "" || strings.ContainsAny(abbr, "[]") || title == "" {
+		return false
+	}
+	if silent {
+		return true
+	}
+
+	s.Line = startLine + 1
+	s.PushToken(&markdown.HTMLBlock{
+		Content: abbrMarker + hex.EncodeToString([]byte(abbr)) + " " + hex.EncodeToString([]byte(title)) + "-->\n",
+		Map:     [2]int{startLine, s.Line},
+	})
+	return true
+}
+
+// abbreviations removes the abbreviation definitions from the document
+// and wraps the abbreviations wherever they occur as whole words in the
+// text in <abbr> elements. Later definitions of an abbreviation win.
+func (d *document) abbreviations() {
+	titles := make(map[string]string)
+	tokens := d.tokens[:0]
+	for _, tok := range d.tokens {
+		if b, ok := tok.(*markdown.HTMLBlock); ok && strings.HasPrefix(b.Content, abbrMarker) {
+			fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(b.Content[len(abbrMarker):]), "-->"))
+			if len(fields) == 2 {
+				abbr, _ := hex.DecodeString(fields[0])
+				title, _ := hex.DecodeString(fields[1])
+				titles[string(abbr)] = string(title)
+			}
+			continue
+		}
+		tokens = append(tokens, tok)
+	}
+	d.tokens = tokens
+	if len(titles) == 0 {
+		return
+	}
+
+	// Try longer abbreviations first, so that "HTML5" is not taken for
+	// "HTML" followed by "5".
+	abbrs := make([]string, 0, len(titles))
+	for abbr := range titles {
+		abbrs = append(abbrs, abbr)
+	}
+	sort.Slice(abbrs, func(i, j int) bool { return len(abbrs[i]) > len(abbrs[j]) })
+	quoted := make([]string, len(abbrs))
+	for i, abbr := range abbrs {
+		quoted[i] = regexp.QuoteMeta(abbr)
+	}
+	re := regexp.MustCompile(strings.Join(quoted, "|"))
+
+	for _, tok := range d.tokens {
+		inline, ok := tok.(*markdown.Inline)
+		if !ok {
+			continue
+		}
+		var children []markdown.Token
+		for _, child := range inline.Children {
+			text, ok := child.(*markdown.Text)
+			if !ok {
+				children = append(children, child)
+				continue
+			}
+			children = append(children, expandAbbreviations(text, re, titles)...)
+		}
+		inline.Children = children
+	}
+}
+
+// expandAbbreviations splits text around the abbreviations re matches.
+func expandAbbreviations(text *markdown.Text, re *regexp.Regexp, titles map[string]string) []markdown.Token {
+	var toks []markdown.Token
+	s, last := text.Content, 0
+	for _, m := range re.FindAllStringIndex(s, -1) {
+		if !wordBoundary(s, m[0], m[1]) {
+			continue
+		}
+		if m[0] > last {
+			toks = append(toks, &markdown.Text{Content: s[last:m[0]], Lvl: text.Lvl})
+		}
+		abbr := s[m[0]:m[1]]
+		toks = append(toks, &markdown.HTMLInline{Content: `<abbr title="` + html.EscapeString(titles[abbr]) + `">` +
+			html.EscapeString(abbr) + "</abbr>"})
+		last = m[1]
+	}
+	if last == 0 {
+		return []markdown.Token{text}
+	}
+	if last < len(s) {
+		text.Content = s[last:]
+		toks = append(toks, text)
+	}
+	return toks
+}
+
+// wordBoundary reports whether s[start:end] is not part of a longer word.
+func wordBoundary(s string, start, end int) bool {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' }
+	if r, _ := utf8.DecodeLastRuneInString(s[:start]); start > 0 && isWord(r) {
+		return false
+	}
+	if r, _ := utf8.DecodeRuneInString(s[end:]); end < len(s) && isWord(r) {
+		return false
+	}
+	return true
+}
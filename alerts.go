@@ -0,0 +1,180 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// alertKinds are GitHub's alert types, in the order their styles are
+// emitted.
+var alertKinds = []string{"note", "tip", "important", "warning", "caution"}
+
+// alertAliases maps other common admonition names onto GitHub's kinds.
+var alertAliases = map[string]string{
+	"info":      "note",
+	"hint":      "tip",
+	"success":   "tip",
+	"attention": "warning",
+	"danger":    "caution",
+	"error":     "caution",
+}
+
+// alertIcons are 16x16 outline icons drawn with the alert's colour.
+var alertIcons = map[string]string{
+	"note":      `<circle cx="8" cy="8" r="6.75"/><path d="M8 7.25v4"/><circle cx="8" cy="4.75" r=".5"/>`,
+	"tip":       `<path d="M5.75 11.5C4.4 10.5 3.5 9 3.5 7.25a4.5 4.5 0 0 1 9 0c0 1.75-.9 3.25-2.25 4.25z"/><path d="M6.25 14h3.5"/>`,
+	"important": `<path d="M1.75 2.25h12.5v9H8.5l-3 2.5v-2.5H1.75z"/><path d="M8 4.5v3.5"/><circle cx="8" cy="9.5" r=".5"/>`,
+	"warning":   `<path d="M8 1.75l6.5 12H1.5z"/><path d="M8 6v3.75"/><circle cx="8" cy="11.75" r=".5"/>`,
+	"caution":   `<path d="M5.25 1.25h5.5l4 4v5.5l-4 4h-5.5l-4-4v-5.5z"/><path d="M8 4.5v4"/><circle cx="8" cy="11" r=".5"/>`,
+}
+
+// containerMarker starts the HTML comment the ::: block rule emits ahead of
+// the blockquote holding a container's contents. Should the comment ever
+// reach the output it is invisible.
+const containerMarker = "<!--mdview-container "
+
+func init() {
+	markdown.RegisterBlockRule(350, ruleContainer, []int{1100, 700, 400, 600})
+}
+
+// ruleContainer parses fenced containers such as
+//
+//	:::warning Optional title
+//	Contents, which may contain any markdown.
+//	:::
+//
+// as a marker comment followed by a blockquote.
+func ruleContainer(s *markdown.StateBlock, startLine, endLine int, silent bool) bool {
+	if s.SCount[startLine]-s.BlkIndent >= 4 {
+		return false
+	}
+	line := s.Src[s.BMarks[startLine]+s.TShift[startLine] : s.EMarks[startLine]]
+	if !strings.HasPrefix(line, ":::") {
+		return false
+	}
+	params := strings.TrimLeft(line, ":")
+	colons := len(line) - len(params)
+	params = strings.TrimSpace(params)
+	if params == "" {
+		return false
+	}
+	if silent {
+		return true
+	}
+
+	nextLine := startLine
+	closed := false
+	for {
+		nextLine++
+		if nextLine >= endLine {
+			break
+		}
+		pos, max := s.BMarks[nextLine]+s.TShift[nextLine], s.EMarks[nextLine]
+		if pos < max && s.SCount[nextLine] < s.BlkIndent {
+			break
+		}
+		text := strings.TrimSpace(s.Src[pos:max])
+		if s.SCount[nextLine]-s.BlkIndent < 4 && len(text) >= colons && strings.Trim(text, ":") == "" {
+			closed = true
+			break
+		}
+	}
+
+	s.PushToken(&markdown.HTMLBlock{
+		Content: containerMarker + strings.Replace(params, "--", "", -1) + "-->\n",
+		Map:     [2]int{startLine, startLine + 1},
+	})
+	oldLineMax := s.LineMax
+	s.LineMax = nextLine
+	s.PushOpeningToken(&markdown.BlockquoteOpen{Map: [2]int{startLine, nextLine}})
+	s.Md.Block.Tokenize(s, startLine+1, nextLine)
+	s.PushClosingToken(&markdown.BlockquoteClose{})
+	s.LineMax = oldLineMax
+
+	s.Line = nextLine
+	if closed {
+		s.Line++
+	}
+	return true
+}
+
+var alertMarker = regexp.MustCompile(`(?i)^\[!(note|tip|important|warning|caution)\]\s*$`)
+
+// alerts turns GitHub's "> [!NOTE]" blockquotes and ::: containers into
+// titled callouts.
+func (d *document) alerts() {
+	for i := 0; i < len(d.tokens); i++ {
+		var kind, title string
+		switch tok := d.tokens[i].(type) {
+		case *markdown.HTMLBlock:
+			if !strings.HasPrefix(tok.Content, containerMarker) || i+1 >= len(d.tokens) {
+				continue
+			}
+			params := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(tok.Content[len(containerMarker):]), "-->"))
+			fields := strings.SplitN(params, " ", 2)
+			kind = strings.ToLower(fields[0])
+			if len(fields) > 1 {
+				title = strings.TrimSpace(fields[1])
+			}
+			d.tokens = append(d.tokens[:i], d.tokens[i+1:]...)
+		case *markdown.BlockquoteOpen:
+			if kind = d.alertMarker(i); kind == "" {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if alias, ok := alertAliases[kind]; ok {
+			kind = alias
+		}
+		if title == "" {
+			title = strings.ToUpper(kind[:1]) + kind[1:]
+		}
+		if _, ok := alertIcons[kind]; !ok {
+			kind = "note"
+		}
+		d.setAttr(d.tokens[i], "class", "markdown-alert markdown-alert-"+kind)
+		d.insert(i+1, &markdown.HTMLBlock{Content: `<p class="markdown-alert-title"><svg viewBox="0 0 16 16" width="16" height="16" ` +
+			`fill="none" stroke="currentColor" stroke-width="1.5" stroke-linecap="round" stroke-linejoin="round" aria-hidden="true">` +
+			alertIcons[kind] + "</svg>" + html.EscapeString(title) + "</p>\n"})
+	}
+}
+
+// alertMarker checks whether the blockquote opened at i starts with a line
+// like [!NOTE], removing it if so, and returns the alert's kind.
+func (d *document) alertMarker(i int) string {
+	if i+2 >= len(d.tokens) {
+		return ""
+	}
+	if _, ok := d.tokens[i+1].(*markdown.ParagraphOpen); !ok {
+		return ""
+	}
+	inline, ok := d.tokens[i+2].(*markdown.Inline)
+	if !ok {
+		return ""
+	}
+	first := inline.Content
+	if n := strings.IndexByte(first, '\n'); n >= 0 {
+		first = first[:n]
+	}
+	m := alertMarker.FindStringSubmatch(first)
+	if m == nil {
+		return ""
+	}
+
+	// Drop the marker's line from the paragraph, or the whole paragraph
+	// when the marker is all there is.
+	for n, child := range inline.Children {
+		if _, ok := child.(*markdown.Softbreak); ok {
+			inline.Children = inline.Children[n+1:]
+			inline.Content = inline.Content[strings.IndexByte(inline.Content, '\n')+1:]
+			return strings.ToLower(m[1])
+		}
+	}
+	d.tokens = append(d.tokens[:i+1], d.tokens[i+4:]...)
+	return strings.ToLower(m[1])
+}
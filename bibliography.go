@@ -0,0 +1,598 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// reference is a work a document may cite, as read from a BibTeX or
+// CSL-JSON bibliography.
+type reference struct {
+	key string
+	// kind is one of "article", "book", "chapter", "thesis", "report"
+	// and "misc".
+	kind      string
+	authors   []personName
+	editors   []personName
+	title     string
+	container string // journal, or book a chapter or paper appears in
+	publisher string // also the school of a thesis or the institution of a report
+	year      string
+	volume    string
+	issue     string
+	pages     string
+	doi       string
+	url       string
+	note      string // thesis type, such as "PhD thesis"
+}
+
+// personName is an author or editor. Names that cannot be split, such as
+// those of organisations, are kept whole in family.
+type personName struct {
+	family, given string
+}
+
+// loadBibliography reads the references in the BibTeX (.bib) or CSL-JSON
+// (.json) file name, keyed by citation key.
+func loadBibliography(name string) (map[string]*reference, error) {
+	dat, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var refs []*reference
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".bib", ".bibtex":
+		refs, err = parseBibTeX(string(dat))
+	case ".json":
+		refs, err = parseCSLJSON(dat)
+	default:
+		return nil, fmt.Errorf("%s: unknown bibliography format, want .bib or .json", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	m := make(map[string]*reference, len(refs))
+	for _, ref := range refs {
+		if _, dup := m[ref.key]; !dup {
+			m[ref.key] = ref
+		}
+	}
+	return m, nil
+}
+
+var bibMonths = map[string]string{
+	"jan": "January", "feb": "February", "mar": "March", "apr": "April",
+	"may": "May", "jun": "June", "jul": "July", "aug": "August",
+	"sep": "September", "oct": "October", "nov": "November", "dec": "December",
+}
+
+// parseBibTeX parses the entries of a BibTeX database. @string macros are
+// expanded; @comment and @preamble are skipped.
+func parseBibTeX(src string) ([]*reference, error) {
+	macros := make(map[string]string, len(bibMonths))
+	for k, v := range bibMonths {
+		macros[k] = v
+	}
+	var refs []*reference
+	p := &bibParser{src: src}
+	for {
+		at := strings.IndexByte(p.src[p.pos:], '@')
+		if at < 0 {
+			return refs, nil
+		}
+		p.pos += at + 1
+		kind := strings.ToLower(p.ident())
+		p.space()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '{' && p.src[p.pos] != '(') {
+			continue
+		}
+		closer := byte('}')
+		if p.src[p.pos] == '(' {
+			closer = ')'
+		}
+		p.pos++
+		switch kind {
+		case "comment", "preamble":
+			p.skipBalanced(closer)
+			continue
+		case "string":
+			fields, err := p.fields(closer, macros)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range fields {
+				macros[k] = v
+			}
+			continue
+		}
+		p.space()
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] != ',' && p.src[p.pos] != closer {
+			p.pos++
+		}
+		key := strings.TrimSpace(p.src[start:p.pos])
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+		}
+		fields, err := p.fields(closer, macros)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %v", key, err)
+		}
+		refs = append(refs, bibReference(key, kind, fields))
+	}
+}
+
+type bibParser struct {
+	src string
+	pos int
+}
+
+func (p *bibParser) space() {
+	for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *bibParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && (isAlphanumeric(p.src[p.pos]) || strings.IndexByte("_-:.+/", p.src[p.pos]) >= 0) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// skipBalanced skips to just after closer, minding nested braces.
+func (p *bibParser) skipBalanced(closer byte) {
+	depth := 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == closer && depth == 0:
+			p.pos++
+			return
+		}
+	}
+}
+
+// fields parses the name = value pairs of an entry up to closer, with the
+// names lower-cased.
+func (p *bibParser) fields(closer byte, macros map[string]string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for {
+		p.space()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("unexpected end of file")
+		}
+		if p.src[p.pos] == closer {
+			p.pos++
+			return fields, nil
+		}
+		if p.src[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		name := strings.ToLower(p.ident())
+		p.space()
+		if name == "" || p.pos >= len(p.src) || p.src[p.pos] != '=' {
+			return nil, fmt.Errorf("expected a field at %q", excerpt(p.src[p.pos:]))
+		}
+		p.pos++
+		var value strings.Builder
+		for {
+			p.space()
+			if p.pos >= len(p.src) {
+				return nil, fmt.Errorf("unexpected end of file")
+			}
+			switch c := p.src[p.pos]; {
+			case c == '{' || c == '"':
+				v, err := p.delimited()
+				if err != nil {
+					return nil, err
+				}
+				value.WriteString(v)
+			case isAlphanumeric(c):
+				word := p.ident()
+				if v, ok := macros[strings.ToLower(word)]; ok {
+					word = v
+				}
+				value.WriteString(word)
+			default:
+				return nil, fmt.Errorf("expected a value at %q", excerpt(p.src[p.pos:]))
+			}
+			p.space()
+			if p.pos < len(p.src) && p.src[p.pos] == '#' {
+				p.pos++
+				continue
+			}
+			break
+		}
+		fields[name] = value.String()
+	}
+}
+
+// delimited parses a {braced} or "quoted" value, keeping the braces nested
+// inside it.
+func (p *bibParser) delimited() (string, error) {
+	open := p.src[p.pos]
+	depth := 0
+	start := p.pos + 1
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c == '\\':
+			p.pos++
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case (open == '{' && c == '}') || (open == '"' && c == '"' && depth == 0):
+			p.pos++
+			return p.src[start : p.pos-1], nil
+		}
+	}
+	return "", fmt.Errorf("unterminated value")
+}
+
+func excerpt(s string) string {
+	if len(s) > 20 {
+		return s[:20] + "..."
+	}
+	return s
+}
+
+// bibReference converts a BibTeX entry of the given type.
+func bibReference(key, kind string, f map[string]string) *reference {
+	ref := &reference{
+		key:       key,
+		authors:   bibNames(f["author"]),
+		editors:   bibNames(f["editor"]),
+		title:     latexText(f["title"]),
+		year:      latexText(f["year"]),
+		volume:    latexText(f["volume"]),
+		issue:     latexText(f["number"]),
+		pages:     latexText(f["pages"]),
+		publisher: latexText(f["publisher"]),
+		doi:       strings.TrimSpace(f["doi"]),
+		url:       strings.TrimSpace(f["url"]),
+	}
+	if ref.year == "" && len(f["date"]) >= 4 {
+		ref.year = f["date"][:4]
+	}
+	if ref.issue == "" {
+		ref.issue = latexText(f["issue"])
+	}
+	switch kind {
+	case "article":
+		ref.kind = "article"
+		ref.container = latexText(f["journal"])
+		if ref.container == "" {
+			ref.container = latexText(f["journaltitle"])
+		}
+	case "book", "booklet", "manual", "proceedings":
+		ref.kind = "book"
+	case "inproceedings", "conference", "incollection", "inbook":
+		ref.kind = "chapter"
+		ref.container = latexText(f["booktitle"])
+	case "phdthesis", "mastersthesis", "thesis":
+		ref.kind = "thesis"
+		ref.publisher = latexText(f["school"])
+		if ref.publisher == "" {
+			ref.publisher = latexText(f["institution"])
+		}
+		ref.note = latexText(f["type"])
+		if ref.note == "" {
+			ref.note = map[string]string{"phdthesis": "PhD thesis", "mastersthesis": "Master's thesis"}[kind]
+		}
+	case "techreport", "report":
+		ref.kind = "report"
+		ref.publisher = latexText(f["institution"])
+		ref.note = latexText(f["type"])
+		if ref.note == "" {
+			ref.note = "Technical report"
+		}
+	default:
+		ref.kind = "misc"
+		ref.container = latexText(f["howpublished"])
+	}
+	if ref.url == "" && strings.HasPrefix(f["howpublished"], `\url{`) {
+		ref.url = strings.TrimSuffix(f["howpublished"][len(`\url{`):], "}")
+		ref.container = ""
+	}
+	return ref
+}
+
+var bibAnd = regexp.MustCompile(`\s+and\s+`)
+
+// bibNames splits a BibTeX name list, as in
+// "Knuth, Donald E. and Leslie Lamport", into names. Anything in braces,
+// such as {Free Software Foundation}, is kept together.
+func bibNames(s string) []personName {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	var names []personName
+	for _, part := range splitTopLevel(s, bibAnd) {
+		part = strings.TrimSpace(part)
+		if part == "others" {
+			names = append(names, personName{family: "others"})
+			continue
+		}
+		var n personName
+		if comma := topLevelIndex(part, ','); comma >= 0 {
+			// "von Last, First" or "von Last, Jr, First".
+			n.family = part[:comma]
+			n.given = part[comma+1:]
+			if c := topLevelIndex(n.given, ','); c >= 0 {
+				n.given = n.given[c+1:]
+			}
+		} else {
+			// "First von Last": the family name starts at the first
+			// lower-case word, or else is the last word.
+			words := strings.Fields(part)
+			if isBraced(part) {
+				words = []string{part}
+			}
+			split := len(words) - 1
+			for i, w := range words[:len(words)-1] {
+				if w[0] >= 'a' && w[0] <= 'z' {
+					split = i
+					break
+				}
+			}
+			n.given = strings.Join(words[:split], " ")
+			n.family = strings.Join(words[split:], " ")
+		}
+		n.family, n.given = latexText(n.family), latexText(n.given)
+		names = append(names, n)
+	}
+	return names
+}
+
+// splitTopLevel splits s around the matches of sep outside braces.
+func splitTopLevel(s string, sep *regexp.Regexp) []string {
+	var parts []string
+	last := 0
+	for _, m := range sep.FindAllStringIndex(s, -1) {
+		if m[0] < last || braceDepth(s[:m[0]]) > 0 {
+			continue
+		}
+		parts = append(parts, s[last:m[0]])
+		last = m[1]
+	}
+	return append(parts, s[last:])
+}
+
+// topLevelIndex returns the index of the first c outside braces in s, or
+// -1.
+func topLevelIndex(s string, c byte) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case c:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// isBraced reports whether s is wholly enclosed in a pair of braces.
+func isBraced(s string) bool {
+	if !strings.HasPrefix(s, "{") {
+		return false
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i == len(s)-1
+			}
+		}
+	}
+	return false
+}
+
+func braceDepth(s string) int {
+	return strings.Count(s, "{") - strings.Count(s, "}")
+}
+
+// latexAccents maps the accent commands of LaTeX to combining characters.
+var latexAccents = map[byte]string{
+	'"': "̈", '\'': "́", '`': "̀", '^': "̂", '~': "̃",
+	'=': "̄", '.': "̇", 'u': "̆", 'v': "̌", 'H': "̋",
+	'c': "̧", 'k': "̨", 'r': "̊",
+}
+
+// latexSymbols maps LaTeX commands without arguments to their text.
+var latexSymbols = map[string]string{
+	"ss": "ß", "o": "ø", "O": "Ø", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ",
+	"aa": "å", "AA": "Å", "l": "ł", "L": "Ł", "i": "ı", "j": "ȷ",
+	"textendash": "–", "textemdash": "—", "LaTeX": "LaTeX", "TeX": "TeX",
+}
+
+// latexText turns the LaTeX markup of a BibTeX field into plain text: it
+// resolves accents, escapes, dashes and ties, drops formatting commands
+// such as \emph while keeping their arguments, and removes braces.
+func latexText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' || c == '}':
+		case c == '~':
+			b.WriteString(" ")
+		case c == '-' && strings.HasPrefix(s[i:], "---"):
+			b.WriteString("—")
+			i += 2
+		case c == '-' && strings.HasPrefix(s[i:], "--"):
+			b.WriteString("–")
+			i++
+		case c == '\n' || c == '\t' || c == '\r':
+			b.WriteByte(' ')
+		case c == '\\' && i+1 < len(s):
+			next := s[i+1]
+			if mark, ok := latexAccents[next]; ok && (!isAlphanumeric(next) || i+2 < len(s) && !isAlphanumeric(s[i+2])) {
+				// \"o, \"{o}, \c c and the like.
+				j := i + 2
+				for j < len(s) && (s[j] == '{' || s[j] == ' ') {
+					j++
+				}
+				if j < len(s) {
+					base := s[j]
+					if base == '\\' && j+1 < len(s) && s[j+1] == 'i' {
+						// \'{\i} is an accented dotless i.
+						base = 'i'
+						j++
+					}
+					b.WriteByte(base)
+					b.WriteString(mark)
+					i = j
+					continue
+				}
+			}
+			if !isAlphanumeric(next) {
+				// \&, \%, \_, \$, \# and \\.
+				if next == '\\' {
+					b.WriteByte(' ')
+				} else {
+					b.WriteByte(next)
+				}
+				i++
+				continue
+			}
+			j := i + 1
+			for j < len(s) && isAlphanumeric(s[j]) {
+				j++
+			}
+			if sym, ok := latexSymbols[s[i+1:j]]; ok {
+				b.WriteString(sym)
+			}
+			// As in TeX, a space after a control word only ends it.
+			if j < len(s) && s[j] == ' ' {
+				j++
+			}
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// parseCSLJSON parses a bibliography in the CSL-JSON format written by
+// Zotero, Pandoc and most reference managers.
+func parseCSLJSON(dat []byte) ([]*reference, error) {
+	var items []struct {
+		ID             interface{} `json:"id"`
+		Type           string      `json:"type"`
+		Title          string      `json:"title"`
+		Author         []cslName   `json:"author"`
+		Editor         []cslName   `json:"editor"`
+		ContainerTitle string      `json:"container-title"`
+		Publisher      string      `json:"publisher"`
+		Genre          string      `json:"genre"`
+		Volume         interface{} `json:"volume"`
+		Issue          interface{} `json:"issue"`
+		Page           interface{} `json:"page"`
+		DOI            string      `json:"DOI"`
+		URL            string      `json:"URL"`
+		Issued         struct {
+			DateParts [][]interface{} `json:"date-parts"`
+			Literal   string          `json:"literal"`
+			Raw       string          `json:"raw"`
+		} `json:"issued"`
+	}
+	if err := json.Unmarshal(dat, &items); err != nil {
+		return nil, err
+	}
+	refs := make([]*reference, 0, len(items))
+	for _, it := range items {
+		ref := &reference{
+			key:       cslString(it.ID),
+			title:     it.Title,
+			authors:   cslNames(it.Author),
+			editors:   cslNames(it.Editor),
+			container: it.ContainerTitle,
+			publisher: it.Publisher,
+			volume:    cslString(it.Volume),
+			issue:     cslString(it.Issue),
+			pages:     strings.Replace(cslString(it.Page), "-", "–", -1),
+			doi:       it.DOI,
+			url:       it.URL,
+			note:      it.Genre,
+		}
+		switch {
+		case len(it.Issued.DateParts) > 0 && len(it.Issued.DateParts[0]) > 0:
+			ref.year = cslString(it.Issued.DateParts[0][0])
+		case it.Issued.Literal != "":
+			ref.year = it.Issued.Literal
+		case len(it.Issued.Raw) >= 4:
+			ref.year = it.Issued.Raw[:4]
+		}
+		switch it.Type {
+		case "article", "article-journal", "article-magazine", "article-newspaper":
+			ref.kind = "article"
+		case "book":
+			ref.kind = "book"
+		case "chapter", "paper-conference", "entry-encyclopedia", "entry-dictionary":
+			ref.kind = "chapter"
+		case "thesis":
+			ref.kind = "thesis"
+		case "report":
+			ref.kind = "report"
+		default:
+			ref.kind = "misc"
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+type cslName struct {
+	Family  string `json:"family"`
+	Given   string `json:"given"`
+	Literal string `json:"literal"`
+	// Particles such as the "van" in "Ludwig van Beethoven".
+	NonDroppingParticle string `json:"non-dropping-particle"`
+}
+
+func cslNames(names []cslName) []personName {
+	var out []personName
+	for _, n := range names {
+		switch {
+		case n.Literal != "":
+			out = append(out, personName{family: n.Literal})
+		case n.NonDroppingParticle != "":
+			out = append(out, personName{family: n.NonDroppingParticle + " " + n.Family, given: n.Given})
+		default:
+			out = append(out, personName{family: n.Family, given: n.Given})
+		}
+	}
+	return out
+}
+
+// cslString formats a CSL-JSON value, which may be a string or a number.
+func cslString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBibTeX(t *testing.T) {
+	src := `
+@comment{ignored @article{nope, title = {No}} }
+@preamble{"\newcommand{\noop}[1]{}"}
+@string{acm = "Communications of the ACM"}
+
+@article{knuth1984,
+  author  = {Knuth, Donald E. and Leslie Lamport},
+  title   = {Literate {P}rogramming},
+  journal = acm # " (CACM)",
+  year    = 1984,
+  month   = may,
+  volume  = {27},
+  number  = "2",
+  pages   = {97--111},
+  doi     = {10.1093/comjnl/27.2.97},
+}
+
+@book(gnu, author = {{Free Software Foundation}}, title = "The {GNU} Manual", publisher = {FSF}, date = {2001-04-01})
+
+@phdthesis{goedel,
+  author = {G{\"o}del, Kurt},
+  title  = {\emph{\"Uber} die Vollst\"andigkeit},
+  school = {Universit\"at Wien},
+  year   = {1929},
+}
+
+@techreport{tr, author = {Doe, Jane}, title = {Report}, institution = {ACME}, year = {2020}}
+
+@misc{site, title = {Site}, howpublished = {\url{https://example.com}}}
+
+@inproceedings{paper, author = {Roe, R.}, title = {Paper}, booktitle = {Proc. Conf.}, year = {2019}}
+`
+	want := []*reference{{
+		key:       "knuth1984",
+		kind:      "article",
+		authors:   []personName{{family: "Knuth", given: "Donald E."}, {family: "Lamport", given: "Leslie"}},
+		title:     "Literate Programming",
+		container: "Communications of the ACM (CACM)",
+		year:      "1984",
+		volume:    "27",
+		issue:     "2",
+		pages:     "97–111",
+		doi:       "10.1093/comjnl/27.2.97",
+	}, {
+		key:       "gnu",
+		kind:      "book",
+		authors:   []personName{{family: "Free Software Foundation"}},
+		title:     "The GNU Manual",
+		publisher: "FSF",
+		year:      "2001",
+	}, {
+		key:       "goedel",
+		kind:      "thesis",
+		authors:   []personName{{family: "Go\u0308del", given: "Kurt"}},
+		title:     "U\u0308ber die Vollsta\u0308ndigkeit",
+		publisher: "Universita\u0308t Wien",
+		year:      "1929",
+		note:      "PhD thesis",
+	}, {
+		key:       "tr",
+		kind:      "report",
+		authors:   []personName{{family: "Doe", given: "Jane"}},
+		title:     "Report",
+		publisher: "ACME",
+		year:      "2020",
+		note:      "Technical report",
+	}, {
+		key:   "site",
+		kind:  "misc",
+		title: "Site",
+		url:   "https://example.com",
+	}, {
+		key:       "paper",
+		kind:      "chapter",
+		authors:   []personName{{family: "Roe", given: "R."}},
+		title:     "Paper",
+		container: "Proc. Conf.",
+		year:      "2019",
+	}}
+	got, err := parseBibTeX(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parsed %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("entry %d:\n got %+v\nwant %+v", i, *got[i], *want[i])
+		}
+	}
+}
+
+func TestParseBibTeXErrors(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{`@article{a, title = {Open`, "unterminated value"},
+		{`@article{a, title = "Open`, "unterminated value"},
+		{`@article{a, title = {T}`, "unexpected end of file"},
+		{`@article{a, = {T}}`, "entry a: expected a field"},
+		{`@article{a, title = }`, "entry a: expected a value"},
+		{`@string{x = {Open}`, "unexpected end of file"},
+	}
+	for _, tt := range tests {
+		_, err := parseBibTeX(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseBibTeX(%q) error = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestBibNames(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []personName
+	}{
+		{"", nil},
+		{"Leslie Lamport", []personName{{family: "Lamport", given: "Leslie"}}},
+		{"Knuth, Donald E.", []personName{{family: "Knuth", given: "Donald E."}}},
+		{"Ludwig van Beethoven", []personName{{family: "van Beethoven", given: "Ludwig"}}},
+		{"van Beethoven, Ludwig", []personName{{family: "van Beethoven", given: "Ludwig"}}},
+		{"King, Jr, Martin Luther", []personName{{family: "King", given: "Martin Luther"}}},
+		{"{Barnes and Noble}", []personName{{family: "Barnes and Noble"}}},
+		{"{Barnes and Noble} and Plato", []personName{{family: "Barnes and Noble"}, {family: "Plato"}}},
+		{"A. Smith and others", []personName{{family: "Smith", given: "A."}, {family: "others"}}},
+	}
+	for _, tt := range tests {
+		if got := bibNames(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bibNames(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLatexText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{The} {GNU} Manual`, "The GNU Manual"},
+		{`Pages 1--2, a---b`, "Pages 1–2, a—b"},
+		{`Knuth~D.`, "Knuth D."},
+		{`R\&D, 50\%, a\_b`, "R&D, 50%, a_b"},
+		{`\"o \"{o} \'{e} \c{c} \'{\i}`, "o\u0308 o\u0308 e\u0301 c\u0327 i\u0301"},
+		{`\emph{very} \textbf{bold}`, "very bold"},
+		{`\ss{} and \o`, "ß and ø"},
+		{"line\nbreak", "line break"},
+	}
+	for _, tt := range tests {
+		if got := latexText(tt.in); got != tt.want {
+			t.Errorf("latexText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseCSLJSON(t *testing.T) {
+	src := `[
+	  {"id": "knuth1984", "type": "article-journal", "title": "Literate Programming",
+	   "author": [{"family": "Knuth", "given": "Donald E."}],
+	   "container-title": "The Computer Journal", "volume": 27, "issue": "2",
+	   "page": "97-111", "DOI": "10.1093/comjnl/27.2.97",
+	   "issued": {"date-parts": [[1984, 5]]}},
+	  {"id": 7, "type": "book", "title": "Symphony",
+	   "author": [{"family": "Beethoven", "given": "Ludwig", "non-dropping-particle": "van"}, {"literal": "Vienna Philharmonic"}],
+	   "issued": {"raw": "1808-12-22"}},
+	  {"id": "web", "type": "webpage", "title": "Site", "URL": "https://example.com", "issued": {"literal": "n.d."}}
+	]`
+	want := []*reference{{
+		key:       "knuth1984",
+		kind:      "article",
+		authors:   []personName{{family: "Knuth", given: "Donald E."}},
+		title:     "Literate Programming",
+		container: "The Computer Journal",
+		year:      "1984",
+		volume:    "27",
+		issue:     "2",
+		pages:     "97–111",
+		doi:       "10.1093/comjnl/27.2.97",
+	}, {
+		key:     "7",
+		kind:    "book",
+		authors: []personName{{family: "van Beethoven", given: "Ludwig"}, {family: "Vienna Philharmonic"}},
+		title:   "Symphony",
+		year:    "1808",
+	}, {
+		key:   "web",
+		kind:  "misc",
+		title: "Site",
+		url:   "https://example.com",
+		year:  "n.d.",
+	}}
+	got, err := parseCSLJSON([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parsed %d items, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("item %d:\n got %+v\nwant %+v", i, *got[i], *want[i])
+		}
+	}
+	if _, err := parseCSLJSON([]byte(`{"id": "x"}`)); err == nil {
+		t.Error("parseCSLJSON of an object, not an array, succeeded")
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// charts replaces ```chart fences with SVG charts. A chart fence starts
+// with "key: value" header lines and continues with CSV or JSON data:
+//
+//	type: bar
+//	title: Requests per second
+//	x: version
+//	y: rps, p99
+//
+//	version,rps,p99
+//	v1.0,1200,35
+//	v1.1,1450,31
+//
+// The type is bar (the default), line or pie; x names the column holding
+// the labels, by default the first, and y the columns to plot, by default
+// all other numeric ones.
+func (d *document) charts(t *theme) {
+	if t == nil {
+		t = lightTheme
+	}
+	for i, tok := range d.tokens {
+		fence, ok := tok.(*markdown.Fence)
+		if !ok {
+			continue
+		}
+		if fields := strings.Fields(fence.Params); len(fields) == 0 || fields[0] != "chart" {
+			continue
+		}
+		var out string
+		if c, err := parseChart(fence.Content); err != nil {
+			out = `<pre class="mdview-filter-error">chart: ` + html.EscapeString(err.Error()) + "</pre>\n" +
+				"<pre><code>" + html.EscapeString(fence.Content) + "</code></pre>\n"
+		} else {
+			out = `<div class="mdview-chart">` + c.svg(t.palette) + "</div>\n"
+		}
+		d.tokens[i] = &markdown.HTMLBlock{Content: out, Map: fence.Map, Lvl: fence.Lvl}
+	}
+}
+
+// chart is a parsed chart fence.
+type chart struct {
+	kind   string
+	title  string
+	labels []string
+	series []series
+}
+
+type series struct {
+	name   string
+	values []float64
+}
+
+func parseChart(src string) (*chart, error) {
+	header := map[string]string{}
+	lines := strings.Split(src, "\n")
+	n := 0
+	for ; n < len(lines); n++ {
+		line := strings.TrimSpace(lines[n])
+		if line == "" || line == "---" {
+			if len(header) > 0 {
+				n++
+				break
+			}
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 || strings.ContainsAny(line[:colon], ` ,"{[`) {
+			break
+		}
+		header[strings.ToLower(line[:colon])] = strings.TrimSpace(line[colon+1:])
+	}
+	data := strings.TrimSpace(strings.Join(lines[n:], "\n"))
+	if data == "" {
+		return nil, errors.New("no data")
+	}
+
+	var columns []string
+	var rows [][]string
+	var err error
+	if strings.HasPrefix(data, "[") {
+		columns, rows, err = jsonTable(data)
+	} else {
+		r := csv.NewReader(strings.NewReader(data))
+		r.TrimLeadingSpace = true
+		r.FieldsPerRecord = -1
+		var records [][]string
+		records, err = r.ReadAll()
+		if err == nil && len(records) > 0 {
+			columns, rows = records[0], records[1:]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no data rows")
+	}
+	index := map[string]int{}
+	for i, c := range columns {
+		index[strings.TrimSpace(c)] = i
+	}
+	cell := func(row []string, i int) string {
+		if i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	c := &chart{kind: strings.ToLower(header["type"]), title: header["title"]}
+	switch c.kind {
+	case "":
+		c.kind = "bar"
+	case "bar", "line", "pie":
+	default:
+		return nil, fmt.Errorf("unknown chart type %q", c.kind)
+	}
+
+	x := 0
+	if name, ok := header["x"]; ok {
+		if x, ok = index[name]; !ok {
+			return nil, fmt.Errorf("no column %q", name)
+		}
+	}
+	var ys []int
+	if names, ok := header["y"]; ok {
+		for _, name := range strings.Split(names, ",") {
+			i, ok := index[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("no column %q", strings.TrimSpace(name))
+			}
+			ys = append(ys, i)
+		}
+	} else {
+		for i := range columns {
+			if i == x {
+				continue
+			}
+			if _, err := strconv.ParseFloat(cell(rows[0], i), 64); err == nil {
+				ys = append(ys, i)
+			}
+		}
+	}
+	if len(ys) == 0 {
+		return nil, errors.New("no numeric columns")
+	}
+
+	for _, row := range rows {
+		c.labels = append(c.labels, cell(row, x))
+	}
+	for _, y := range ys {
+		s := series{name: strings.TrimSpace(columns[y])}
+		for _, row := range rows {
+			v, err := strconv.ParseFloat(cell(row, y), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not a number", s.name, cell(row, y))
+			}
+			s.values = append(s.values, v)
+		}
+		c.series = append(c.series, s)
+	}
+	return c, nil
+}
+
+// jsonTable reads an array of JSON objects as a table, taking the columns
+// in the order they appear in the first object.
+func jsonTable(data string) ([]string, [][]string, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, nil, err
+	}
+	var columns []string
+	var rows [][]string
+	for n, obj := range raw {
+		dec := json.NewDecoder(strings.NewReader(string(obj)))
+		dec.UseNumber()
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil, nil, errors.New("data must be an array of objects")
+		}
+		row := make([]string, len(columns))
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, nil, err
+			}
+			key := tok.(string)
+			var value interface{}
+			if err = dec.Decode(&value); err != nil {
+				return nil, nil, err
+			}
+			i := indexOf(columns, key)
+			if i < 0 {
+				if n > 0 {
+					continue
+				}
+				columns = append(columns, key)
+				row = append(row, "")
+				i = len(columns) - 1
+			}
+			row[i] = fmt.Sprint(value)
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// The size of a chart, and the margins around its plot area.
+const (
+	chartWidth   = 600
+	chartHeight  = 320
+	chartLeft    = 56
+	chartRight   = 16
+	chartTop     = 40
+	chartBottom  = 40
+	chartPadding = 8
+)
+
+func (c *chart) svg(palette []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" class="mdview-chart-%s" font-size="12px" fill="currentColor">`,
+		chartWidth, chartHeight, chartWidth, chartHeight, c.kind)
+	if c.title != "" {
+		fmt.Fprintf(&b, `<text x="%d" y="20" text-anchor="middle" font-size="14px" font-weight="600">%s</text>`, chartWidth/2, html.EscapeString(c.title))
+	}
+	if c.kind == "pie" {
+		c.pie(&b, palette)
+	} else {
+		c.axes(&b, palette)
+	}
+	b.WriteString("</svg>")
+	return b.String()
+}
+
+func color(palette []string, i int) string {
+	return palette[i%len(palette)]
+}
+
+// axes draws a bar or line chart.
+func (c *chart) axes(b *strings.Builder, palette []string) {
+	lo, hi := 0.0, 0.0
+	if c.kind == "line" {
+		// Unlike bars, lines need not start at zero.
+		lo, hi = c.series[0].values[0], c.series[0].values[0]
+	}
+	for _, s := range c.series {
+		for _, v := range s.values {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	lo, hi, step := niceScale(lo, hi)
+
+	top, bottom := float64(chartTop), float64(chartHeight-chartBottom)
+	left, right := float64(chartLeft), float64(chartWidth-chartRight)
+	y := func(v float64) float64 { return bottom - (v-lo)/(hi-lo)*(bottom-top) }
+
+	// Grid lines and their values.
+	for v := lo; v <= hi+step/2; v += step {
+		fmt.Fprintf(b, `<line x1="%g" y1="%.1f" x2="%g" y2="%.1f" stroke="currentColor" stroke-opacity=".15"/>`, left, y(v), right, y(v))
+		fmt.Fprintf(b, `<text x="%g" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, left-6, y(v), formatNumber(v))
+	}
+	fmt.Fprintf(b, `<line x1="%g" y1="%.1f" x2="%g" y2="%.1f" stroke="currentColor" stroke-opacity=".5"/>`, left, y(math.Max(lo, 0)), right, y(math.Max(lo, 0)))
+
+	n := len(c.labels)
+	slot := (right - left) / float64(n)
+	every := int(math.Ceil(float64(n) * 60 / (right - left))) // keep labels about 60px apart
+	for i, label := range c.labels {
+		if i%every != 0 {
+			continue
+		}
+		fmt.Fprintf(b, `<text x="%.1f" y="%g" text-anchor="middle">%s</text>`, left+slot*(float64(i)+.5), bottom+18, html.EscapeString(label))
+	}
+
+	if c.kind == "bar" {
+		base := y(math.Max(lo, 0))
+		width := (slot - 2*chartPadding) / float64(len(c.series))
+		for si, s := range c.series {
+			for i, v := range s.values {
+				x := left + slot*float64(i) + chartPadding + width*float64(si)
+				y0, y1 := base, y(v)
+				if y1 > y0 {
+					y0, y1 = y1, y0
+				}
+				fmt.Fprintf(b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s</title></rect>`,
+					x, y1, math.Max(width-1, 1), y0-y1, color(palette, si), html.EscapeString(s.name+" "+c.labels[i]+": "+formatNumber(v)))
+			}
+		}
+	} else {
+		for si, s := range c.series {
+			var points []string
+			for i, v := range s.values {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", left+slot*(float64(i)+.5), y(v)))
+			}
+			fmt.Fprintf(b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(points, " "), color(palette, si))
+			for i, v := range s.values {
+				fmt.Fprintf(b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s</title></circle>`,
+					left+slot*(float64(i)+.5), y(v), color(palette, si), html.EscapeString(s.name+" "+c.labels[i]+": "+formatNumber(v)))
+			}
+		}
+	}
+
+	if len(c.series) > 1 {
+		x := right
+		for si := len(c.series) - 1; si >= 0; si-- {
+			name := c.series[si].name
+			x -= float64(len([]rune(name)))*7 + 24
+			fmt.Fprintf(b, `<rect x="%.1f" y="%d" width="10" height="10" fill="%s"/><text x="%.1f" y="%d">%s</text>`,
+				x, chartTop-22, color(palette, si), x+14, chartTop-13, html.EscapeString(name))
+		}
+	}
+}
+
+// pie draws the first series as a pie chart with a legend.
+func (c *chart) pie(b *strings.Builder, palette []string) {
+	s := c.series[0]
+	total := 0.0
+	for _, v := range s.values {
+		if v < 0 {
+			fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle">pie charts cannot show negative values</text>`, chartWidth/2, chartHeight/2)
+			return
+		}
+		total += v
+	}
+	if total == 0 {
+		return
+	}
+	cx, cy := 170.0, float64(chartTop+chartHeight)/2
+	r := float64(chartHeight-chartTop)/2 - 12
+	angle := -math.Pi / 2
+	for i, v := range s.values {
+		title := html.EscapeString(c.labels[i] + ": " + formatNumber(v))
+		if v == total {
+			fmt.Fprintf(b, `<circle cx="%g" cy="%g" r="%g" fill="%s"><title>%s</title></circle>`, cx, cy, r, color(palette, i), title)
+			break
+		}
+		next := angle + v/total*2*math.Pi
+		large := 0
+		if next-angle > math.Pi {
+			large = 1
+		}
+		fmt.Fprintf(b, `<path d="M%g %gL%.2f %.2fA%g %g 0 %d 1 %.2f %.2fz" fill="%s" stroke="currentColor" stroke-opacity=".1"><title>%s</title></path>`,
+			cx, cy, cx+r*math.Cos(angle), cy+r*math.Sin(angle), r, r, large, cx+r*math.Cos(next), cy+r*math.Sin(next), color(palette, i), title)
+		angle = next
+	}
+	for i, v := range s.values {
+		y := chartTop + 10 + i*22
+		fmt.Fprintf(b, `<rect x="360" y="%d" width="12" height="12" fill="%s"/><text x="380" y="%d">%s (%s%%)</text>`,
+			y, color(palette, i), y+10, html.EscapeString(c.labels[i]), formatNumber(math.Round(v/total*1000)/10))
+	}
+}
+
+// niceScale widens lo and hi to round numbers and returns the spacing of
+// about five grid lines between them.
+func niceScale(lo, hi float64) (float64, float64, float64) {
+	if hi == lo {
+		hi = lo + 1
+	}
+	raw := (hi - lo) / 5
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := mag * 10
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if raw <= m*mag {
+			step = m * mag
+			break
+		}
+	}
+	return math.Floor(lo/step) * step, math.Ceil(hi/step) * step, step
+}
+
+func formatNumber(v float64) string {
+	if math.Abs(v) < 1e-9 {
+		return "0"
+	}
+	return strconv.FormatFloat(math.Round(v*1e6)/1e6, 'g', 10, 64)
+}
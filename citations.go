@@ -0,0 +1,649 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// Citation styles.
+const (
+	// citeAuthorYear cites as in (Knuth 1984, 97) and lists the works
+	// cited by author, as in the Chicago author-date style.
+	citeAuthorYear = "author-year"
+	// citeNumeric cites as in [1, p. 97] and lists the works cited in
+	// the order they are first cited, as in the IEEE style.
+	citeNumeric = "numeric"
+)
+
+func parseCitationStyle(s string) (string, error) {
+	switch s {
+	case citeAuthorYear, citeNumeric:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown citation style %q, want %s or %s", s, citeAuthorYear, citeNumeric)
+}
+
+// references loads the bibliography of the document in the file name: the
+// one given with -bibliography, or else those named by "bibliography" in
+// its front matter, relative to the document. It returns the references,
+// the citation style to use and the files read, along with any problems
+// found.
+func (r *renderer) references(name string, meta metadata) (map[string]*reference, string, []string, []string) {
+	var warnings []string
+	style := r.citationStyle
+	if style == "" {
+		style = citeAuthorYear
+		if s := meta.str("citation-style"); s != "" {
+			var err error
+			if style, err = parseCitationStyle(s); err != nil {
+				warnings = append(warnings, err.Error())
+				style = citeAuthorYear
+			}
+		}
+	}
+
+	files := meta.list("bibliography")
+	if r.bibliography != "" {
+		files = []string{r.bibliography}
+	}
+	if len(files) == 0 {
+		return nil, style, nil, warnings
+	}
+	refs := make(map[string]*reference)
+	for i, f := range files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(filepath.Dir(name), f)
+		}
+		files[i] = f
+		m, err := loadBibliography(f)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		for k, ref := range m {
+			if _, dup := refs[k]; !dup {
+				refs[k] = ref
+			}
+		}
+	}
+	return refs, style, files, warnings
+}
+
+// citation is a citation of one or more works, such as
+// [see @knuth1984, p. 97; @lamport1994].
+type citation struct {
+	items []citeItem
+	// narrative is set for citations in running text, such as
+	// "@knuth1984 shows", which name the authors outside the brackets.
+	narrative bool
+}
+
+type citeItem struct {
+	prefix, key, locator string
+	// suppressAuthor leaves the author out, as in [-@knuth1984].
+	suppressAuthor bool
+}
+
+const citationKey = `[\pL\pN_](?:[\pL\pN_:.#$%&+?<>~/-]*[\pL\pN_])?`
+
+var (
+	citeItemPattern = regexp.MustCompile(`^\s*(.*?)\s*(-?)@(` + citationKey + `)(.*?)\s*$`)
+	narrativeCite   = regexp.MustCompile(`(-?)@(` + citationKey + `)`)
+)
+
+// citations resolves citations such as [@knuth1984], [see @knuth1984, p. 97;
+// @lamport1994] and "@knuth1984 shows" against refs in the given style, and
+// adds a bibliography of the works cited, along with those the front
+// matter's nocite lists, at the end of the document. Bracketed citations of
+// unknown works are left alone and returned.
+func (d *document) citations(refs map[string]*reference, style string, nocite []string) []string {
+	var unknown []string
+	var cites []citation
+	var placeholders []*markdown.HTMLInline
+	cited := make(map[string]int)
+	var order []string
+	cite := func(c citation) markdown.Token {
+		for _, it := range c.items {
+			if cited[it.key] == 0 {
+				order = append(order, it.key)
+				cited[it.key] = len(order)
+			}
+		}
+		tok := &markdown.HTMLInline{}
+		cites = append(cites, c)
+		placeholders = append(placeholders, tok)
+		return tok
+	}
+
+	for _, tok := range d.tokens {
+		inline, ok := tok.(*markdown.Inline)
+		if !ok {
+			continue
+		}
+		var children []markdown.Token
+		for _, child := range inline.Children {
+			text, ok := child.(*markdown.Text)
+			if !ok || !strings.Contains(text.Content, "@") {
+				children = append(children, child)
+				continue
+			}
+			toks, missing := expandCitations(text, refs, cite)
+			children = append(children, toks...)
+			unknown = append(unknown, missing...)
+		}
+		inline.Children = children
+	}
+
+	var uncited []string
+	for _, key := range nocite {
+		if key = strings.TrimPrefix(key, "@"); key != "*" {
+			uncited = append(uncited, key)
+			continue
+		}
+		var all []string
+		for k := range refs {
+			all = append(all, k)
+		}
+		sort.Strings(all)
+		uncited = append(uncited, all...)
+	}
+	for _, key := range uncited {
+		if _, ok := refs[key]; ok && cited[key] == 0 {
+			order = append(order, key)
+			cited[key] = len(order)
+		}
+	}
+	if len(order) == 0 {
+		return unknown
+	}
+
+	works := make([]*reference, len(order))
+	for i, key := range order {
+		works[i] = refs[key]
+	}
+	years := make(map[string]string)
+	if style == citeAuthorYear {
+		sort.SliceStable(works, func(i, j int) bool { return sortKey(works[i]) < sortKey(works[j]) })
+		years = disambiguate(works)
+	}
+	for i, c := range cites {
+		placeholders[i].Content = citationHTML(c, refs, style, cited, years)
+	}
+
+	var b strings.Builder
+	b.WriteString("<section class=\"references\" id=\"refs\">\n")
+	if !endsWithReferencesHeading(d.tokens) {
+		b.WriteString("<h2>References</h2>\n")
+	}
+	for _, ref := range works {
+		fmt.Fprintf(&b, "<div class=\"csl-entry\" id=\"ref-%s\">", html.EscapeString(ref.key))
+		if style == citeNumeric {
+			fmt.Fprintf(&b, "<span class=\"csl-number\">[%d]</span> %s", cited[ref.key], numericEntry(ref))
+		} else {
+			b.WriteString(authorYearEntry(ref, years[ref.key]))
+		}
+		b.WriteString("</div>\n")
+	}
+	b.WriteString("</section>\n")
+	d.tokens = append(d.tokens, &markdown.HTMLBlock{Content: b.String()})
+	return unknown
+}
+
+// expandCitations splits text around its citations, which cite hands
+// tokens for, returning the tokens and the keys of unknown works cited in
+// brackets.
+func expandCitations(text *markdown.Text, refs map[string]*reference, cite func(citation) markdown.Token) ([]markdown.Token, []string) {
+	s := text.Content
+	var toks []markdown.Token
+	var unknown []string
+	last := 0
+	plain := func(end int) {
+		if end > last {
+			toks = append(toks, &markdown.Text{Content: s[last:end], Lvl: text.Lvl})
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			end := strings.IndexAny(s[i+1:], "[]")
+			if end < 0 || s[i+1+end] != ']' {
+				continue
+			}
+			c, missing, ok := parseCitation(s[i+1:i+1+end], refs)
+			unknown = append(unknown, missing...)
+			if !ok {
+				continue
+			}
+			plain(i)
+			toks = append(toks, cite(c))
+			i += end + 1
+			last = i + 1
+		case '@', '-':
+			m := narrativeCite.FindStringSubmatchIndex(s[i:])
+			if m == nil || m[0] != 0 {
+				continue
+			}
+			// Leave e-mail addresses and the like alone.
+			if r, _ := utf8.DecodeLastRuneInString(s[:i]); i > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '@') {
+				continue
+			}
+			key := s[i+m[4] : i+m[5]]
+			if _, ok := refs[key]; !ok {
+				continue
+			}
+			end := i + m[1]
+			item := citeItem{key: key, suppressAuthor: m[3] > m[2]}
+			// A locator may follow in brackets, as in @knuth1984 [p. 97].
+			if rest := s[end:]; strings.HasPrefix(rest, " [") {
+				if j := strings.IndexAny(rest[2:], "[]@"); j >= 0 && rest[2+j] == ']' {
+					item.locator = strings.TrimSpace(rest[2 : 2+j])
+					end += 3 + j
+				}
+			}
+			plain(i)
+			toks = append(toks, cite(citation{items: []citeItem{item}, narrative: true}))
+			i = end - 1
+			last = end
+		}
+	}
+	if last == 0 {
+		return []markdown.Token{text}, unknown
+	}
+	if last < len(s) {
+		text.Content = s[last:]
+		toks = append(toks, text)
+	}
+	return toks, unknown
+}
+
+// parseCitation parses the inside of a bracketed citation, returning the
+// keys of the unknown works it cites.
+func parseCitation(s string, refs map[string]*reference) (citation, []string, bool) {
+	if !strings.Contains(s, "@") {
+		return citation{}, nil, false
+	}
+	var c citation
+	var unknown []string
+	for _, part := range strings.Split(s, ";") {
+		m := citeItemPattern.FindStringSubmatch(part)
+		if m == nil {
+			return citation{}, nil, false
+		}
+		if at := strings.Index(part, m[2]+"@"+m[3]); at > 0 && !isSpace(part[at-1]) {
+			// An e-mail address, say.
+			return citation{}, nil, false
+		}
+		if _, ok := refs[m[3]]; !ok {
+			if !crossReference.MatchString("@" + m[3]) {
+				unknown = append(unknown, m[3])
+			}
+			continue
+		}
+		locator := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(m[4]), ","))
+		c.items = append(c.items, citeItem{prefix: m[1], key: m[3], locator: locator, suppressAuthor: m[2] != ""})
+	}
+	if unknown != nil || len(c.items) == 0 {
+		return citation{}, unknown, false
+	}
+	return c, nil, true
+}
+
+// citationHTML renders c in style, with works numbered by cited and
+// dated by years.
+func citationHTML(c citation, refs map[string]*reference, style string, cited map[string]int, years map[string]string) string {
+	var items []string
+	for _, it := range c.items {
+		s := `<a href="#ref-` + html.EscapeString(it.key) + `">`
+		switch {
+		case style == citeNumeric:
+			s += strconv.Itoa(cited[it.key])
+		case it.suppressAuthor || c.narrative:
+			s += years[it.key]
+		default:
+			s += html.EscapeString(citeNames(refs[it.key])) + " " + years[it.key]
+		}
+		s += "</a>"
+		if it.prefix != "" {
+			s = html.EscapeString(it.prefix) + " " + s
+		}
+		if it.locator != "" {
+			s += ", " + html.EscapeString(it.locator)
+		}
+		items = append(items, s)
+	}
+	s := "(" + strings.Join(items, "; ") + ")"
+	if style == citeNumeric {
+		s = "[" + strings.Join(items, ", ") + "]"
+	}
+	if c.narrative && !c.items[0].suppressAuthor {
+		s = html.EscapeString(citeNames(refs[c.items[0].key])) + " " + s
+	}
+	return `<span class="citation">` + s + "</span>"
+}
+
+// endsWithReferencesHeading reports whether the document ends with a
+// heading such as "References" for the bibliography to go under.
+func endsWithReferencesHeading(toks []markdown.Token) bool {
+	if len(toks) < 3 {
+		return false
+	}
+	if _, ok := toks[len(toks)-3].(*markdown.HeadingOpen); !ok {
+		return false
+	}
+	inline, ok := toks[len(toks)-2].(*markdown.Inline)
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(getText(inline))) {
+	case "references", "bibliography", "works cited":
+		return true
+	}
+	return false
+}
+
+// sortKey orders the works in an author-year bibliography.
+func sortKey(ref *reference) string {
+	return strings.ToLower(citeNames(ref) + "\x00" + ref.year + "\x00" + ref.title)
+}
+
+// disambiguate tells apart works by the same authors in the same year by
+// adding letters to their years, as in 1984a and 1984b, returning the
+// years of works by key.
+func disambiguate(works []*reference) map[string]string {
+	years := make(map[string]string, len(works))
+	seen := make(map[string][]*reference)
+	for _, ref := range works {
+		k := citeNames(ref) + "\x00" + ref.year
+		seen[k] = append(seen[k], ref)
+	}
+	for _, ref := range works {
+		year := ref.year
+		if year == "" {
+			year = "n.d."
+		}
+		same := seen[citeNames(ref)+"\x00"+ref.year]
+		if len(same) > 1 {
+			for i, r := range same {
+				if r == ref {
+					year += string(rune('a' + i%26))
+				}
+			}
+		}
+		years[ref.key] = year
+	}
+	return years
+}
+
+// citeNames returns the names works are cited by: "Knuth", "Knuth and
+// Lamport" or "Knuth et al.", falling back on editors and then the title.
+func citeNames(ref *reference) string {
+	names := ref.authors
+	if len(names) == 0 {
+		names = ref.editors
+	}
+	switch {
+	case len(names) == 0:
+		return ref.title
+	case len(names) == 1:
+		return names[0].family
+	case len(names) == 2 && names[1].family != "others":
+		return names[0].family + " and " + names[1].family
+	}
+	return names[0].family + " et al."
+}
+
+// authorYearEntry renders ref as an entry of an author-year bibliography.
+func authorYearEntry(ref *reference, year string) string {
+	var b strings.Builder
+	names, eds := ref.authors, false
+	if len(names) == 0 {
+		names, eds = ref.editors, true
+	}
+	var list []string
+	others := false
+	for i, n := range names {
+		switch {
+		case n.family == "others":
+			others = true
+		case i == 0 && n.given != "":
+			list = append(list, n.family+", "+n.given)
+		default:
+			list = append(list, strings.TrimSpace(n.given+" "+n.family))
+		}
+	}
+	if len(list) > 0 {
+		who := joinNames(list, ", and ")
+		if others {
+			who = strings.Join(list, ", ") + ", et al."
+		}
+		if eds {
+			who += ", ed"
+			if len(list) > 1 || others {
+				who += "s"
+			}
+		}
+		b.WriteString(sentence(html.EscapeString(who)))
+		b.WriteString(" " + year + ". ")
+		b.WriteString(titleHTML(ref, "."))
+	} else {
+		b.WriteString(titleHTML(ref, "."))
+		b.WriteString(" " + year + ".")
+	}
+
+	var rest []string
+	switch ref.kind {
+	case "article":
+		s := "<em>" + html.EscapeString(ref.container) + "</em>"
+		if ref.volume != "" {
+			s += " " + html.EscapeString(ref.volume)
+		}
+		if ref.issue != "" {
+			s += " (" + html.EscapeString(ref.issue) + ")"
+		}
+		if ref.pages != "" {
+			s += ": " + html.EscapeString(ref.pages)
+		}
+		if ref.container != "" {
+			rest = append(rest, s)
+		}
+	case "chapter":
+		s := "In <em>" + html.EscapeString(ref.container) + "</em>"
+		if len(ref.editors) > 0 && len(ref.authors) > 0 {
+			s += ", edited by " + html.EscapeString(fullNames(ref.editors))
+		}
+		if ref.pages != "" {
+			s += ", " + html.EscapeString(ref.pages)
+		}
+		if ref.container != "" {
+			rest = append(rest, s)
+		}
+		rest = append(rest, html.EscapeString(ref.publisher))
+	case "thesis", "report":
+		rest = append(rest, html.EscapeString(joinNonEmpty(", ", ref.note, ref.publisher)))
+	default:
+		rest = append(rest, html.EscapeString(ref.container), html.EscapeString(ref.publisher))
+	}
+	for _, s := range rest {
+		if s != "" {
+			b.WriteString(" " + sentence(s))
+		}
+	}
+	b.WriteString(linkHTML(ref, "."))
+	return b.String()
+}
+
+// numericEntry renders ref as an entry of a numeric bibliography.
+func numericEntry(ref *reference) string {
+	var parts []string
+	names := ref.authors
+	if len(names) == 0 {
+		names = ref.editors
+	}
+	var list []string
+	others := false
+	for _, n := range names {
+		if n.family == "others" {
+			others = true
+		} else {
+			list = append(list, strings.TrimSpace(initials(n.given)+" "+n.family))
+		}
+	}
+	var b strings.Builder
+	if len(list) > 0 {
+		who := joinNames(list, ", and ")
+		if others {
+			who = strings.Join(list, ", ") + " et al."
+		}
+		if len(ref.authors) == 0 {
+			who += ", Ed."
+			if len(list) > 1 || others {
+				who = strings.TrimSuffix(who, "Ed.") + "Eds."
+			}
+		}
+		b.WriteString(html.EscapeString(who) + ", ")
+	}
+	year := ref.year
+	switch ref.kind {
+	case "book":
+		b.WriteString(titleHTML(ref, "."))
+		parts = append(parts, ref.publisher, year)
+		b.WriteString(" " + html.EscapeString(joinNonEmpty(", ", parts...)))
+		b.WriteString(".")
+		b.WriteString(linkHTML(ref, "."))
+		return b.String()
+	case "article":
+		var vol, no, pp string
+		if ref.volume != "" {
+			vol = "vol. " + ref.volume
+		}
+		if ref.issue != "" {
+			no = "no. " + ref.issue
+		}
+		if ref.pages != "" {
+			pp = "pp. " + ref.pages
+		}
+		b.WriteString(titleHTML(ref, ","))
+		if ref.container != "" {
+			b.WriteString(" <em>" + html.EscapeString(ref.container) + "</em>,")
+		}
+		parts = append(parts, vol, no, pp, year)
+	case "chapter":
+		b.WriteString(titleHTML(ref, ","))
+		if ref.container != "" {
+			b.WriteString(" in <em>" + html.EscapeString(ref.container) + "</em>,")
+		}
+		var pp string
+		if ref.pages != "" {
+			pp = "pp. " + ref.pages
+		}
+		parts = append(parts, ref.publisher, year, pp)
+	case "thesis":
+		b.WriteString(titleHTML(ref, ","))
+		parts = append(parts, ref.note, ref.publisher, year)
+	case "report":
+		b.WriteString(titleHTML(ref, ","))
+		parts = append(parts, ref.publisher, ref.note, year)
+	default:
+		b.WriteString(titleHTML(ref, ","))
+		parts = append(parts, ref.container, ref.publisher, year)
+	}
+	b.WriteString(" " + html.EscapeString(joinNonEmpty(", ", parts...)) + ".")
+	b.WriteString(linkHTML(ref, "."))
+	return b.String()
+}
+
+// titleHTML renders the title of ref, in italics for books and quoted
+// with the punctuation that follows it inside the quotes otherwise.
+func titleHTML(ref *reference, punct string) string {
+	title := html.EscapeString(ref.title)
+	if ref.kind == "book" {
+		return "<em>" + title + "</em>" + punct
+	}
+	if strings.HasSuffix(title, "?") || strings.HasSuffix(title, "!") {
+		return "“" + title + "”"
+	}
+	return "“" + title + punct + "”"
+}
+
+// linkHTML renders the DOI or URL of ref as a link.
+func linkHTML(ref *reference, punct string) string {
+	href := ref.url
+	if ref.doi != "" {
+		href = "https://doi.org/" + strings.TrimPrefix(ref.doi, "https://doi.org/")
+	}
+	if href == "" {
+		return ""
+	}
+	href = html.EscapeString(href)
+	return ` <a href="` + href + `">` + href + "</a>" + punct
+}
+
+// fullNames joins names as in "Ann Smith and Bo Li", or "Ann Smith, Bo Li,
+// et al." when the list was cut short with "and others".
+func fullNames(names []personName) string {
+	var list []string
+	for _, n := range names {
+		if n.family == "others" {
+			return strings.Join(list, ", ") + ", et al."
+		}
+		list = append(list, strings.TrimSpace(n.given+" "+n.family))
+	}
+	return joinNames(list, ", and ")
+}
+
+// joinNames joins names as in "A and B" or "A, B<last>C".
+func joinNames(names []string, last string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	case 2:
+		return names[0] + " and " + names[1]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + last + names[len(names)-1]
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	var out []string
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, sep)
+}
+
+// sentence ends s with a full stop unless it already ends with
+// punctuation.
+func sentence(s string) string {
+	if s == "" || strings.HasSuffix(s, ".") || strings.HasSuffix(s, "?") || strings.HasSuffix(s, "!") {
+		return s
+	}
+	return s + "."
+}
+
+// initials abbreviates given names, as in "Donald E." to "D. E." and
+// "Jean-Paul" to "J.-P.".
+func initials(given string) string {
+	var words []string
+	for _, w := range strings.Fields(given) {
+		var parts []string
+		for _, p := range strings.Split(w, "-") {
+			if r, _ := utf8.DecodeRuneInString(p); r != utf8.RuneError {
+				parts = append(parts, string(r)+".")
+			}
+		}
+		words = append(words, strings.Join(parts, "-"))
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestEntryNames(t *testing.T) {
+	knuth, lamport := personName{family: "Knuth", given: "Donald E."}, personName{family: "Lamport", given: "Leslie"}
+	others := personName{family: "others"}
+	tests := []struct {
+		authors, editors          []personName
+		cite, authorYear, numeric string
+	}{
+		{
+			authors: []personName{knuth},
+			cite:    "Knuth", authorYear: "Knuth, Donald E. 1984. “TeX.”", numeric: "D. E. Knuth, “TeX,” 1984.",
+		},
+		{
+			authors: []personName{knuth, lamport},
+			cite:    "Knuth and Lamport", authorYear: "Knuth, Donald E. and Leslie Lamport. 1984. “TeX.”", numeric: "D. E. Knuth and L. Lamport, “TeX,” 1984.",
+		},
+		{
+			authors: []personName{knuth, others},
+			cite:    "Knuth et al.", authorYear: "Knuth, Donald E., et al. 1984. “TeX.”", numeric: "D. E. Knuth et al., “TeX,” 1984.",
+		},
+		{
+			authors: []personName{knuth, lamport, others},
+			cite:    "Knuth et al.", authorYear: "Knuth, Donald E., Leslie Lamport, et al. 1984. “TeX.”", numeric: "D. E. Knuth, L. Lamport et al., “TeX,” 1984.",
+		},
+		{
+			editors: []personName{knuth, others},
+			cite:    "Knuth et al.", authorYear: "Knuth, Donald E., et al., eds. 1984. “TeX.”", numeric: "D. E. Knuth et al., Eds., “TeX,” 1984.",
+		},
+	}
+	for _, tt := range tests {
+		ref := &reference{kind: "misc", authors: tt.authors, editors: tt.editors, title: "TeX", year: "1984"}
+		if got := citeNames(ref); got != tt.cite {
+			t.Errorf("citeNames(%v, %v) = %q, want %q", tt.authors, tt.editors, got, tt.cite)
+		}
+		if got := authorYearEntry(ref, ref.year); got != tt.authorYear {
+			t.Errorf("authorYearEntry(%v, %v) = %q, want %q", tt.authors, tt.editors, got, tt.authorYear)
+		}
+		if got := numericEntry(ref); got != tt.numeric {
+			t.Errorf("numericEntry(%v, %v) = %q, want %q", tt.authors, tt.editors, got, tt.numeric)
+		}
+	}
+	if got, want := fullNames([]personName{knuth, lamport, others}), "Donald E. Knuth, Leslie Lamport, et al."; got != want {
+		t.Errorf("fullNames = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config is mdview's configuration file, a small subset of TOML:
+//
+//	# comment
+//	filter-timeout = "10s"
+//	emoji = "span"
+//	fold = true
+//	figure-numbers = true
+//	section-numbers = true
+//
+//	[filters]
+//	dot = "dot -Tsvg"
+//
+//	[exec]
+//	python = "python3.12"
+//
+// Values are strings, numbers or booleans. Keys before the first [section]
+// belong to the section named "".
+type config map[string]map[string]string
+
+// defaultConfigPath is where the configuration is read from unless
+// -config says otherwise.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mdview", "config.toml")
+}
+
+// loadConfig reads the configuration file name. A missing file is only an
+// error if it was named explicitly.
+func loadConfig(name string) (config, error) {
+	explicit := name != ""
+	if !explicit {
+		name = defaultConfigPath()
+	}
+	c := config{}
+	if name == "" {
+		return c, nil
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) && !explicit {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: bad section header", name, n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", name, n)
+		}
+		key := unquoteKey(strings.TrimSpace(line[:eq]))
+		value, err := configValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		if c[section] == nil {
+			c[section] = make(map[string]string)
+		}
+		c[section][key] = value
+	}
+	return c, scanner.Err()
+}
+
+func unquoteKey(key string) string {
+	if s, err := strconv.Unquote(key); err == nil {
+		return s
+	}
+	return key
+}
+
+// configValue parses a value, dropping any trailing comment.
+func configValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		for i := 1; i < len(v); i++ {
+			switch v[i] {
+			case '\\':
+				i++
+			case '"':
+				if rest := strings.TrimSpace(v[i+1:]); rest != "" && rest[0] != '#' {
+					return "", fmt.Errorf("unexpected %q after string", rest)
+				}
+				return strconv.Unquote(v[:i+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated string")
+	case strings.HasPrefix(v, "'"):
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return v[1 : end+1], nil
+	}
+	if i := strings.IndexByte(v, '#'); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	if v == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	return v, nil
+}
+
+// duration returns the duration set by key in section, or def.
+func (c config) duration(section, key string, def time.Duration) (time.Duration, error) {
+	v, ok := c[section][key]
+	if !ok {
+		return def, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", key, err)
+	}
+	return d, nil
+}
+
+// bool returns the boolean set by key in section, or def.
+func (c config) bool(section, key string, def bool) (bool, error) {
+	v, ok := c[section][key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", key, err)
+	}
+	return b, nil
+}
+
+// int returns the integer set by key in section, or def.
+func (c config) int(section, key string, def int) (int, error) {
+	v, ok := c[section][key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", key, err)
+	}
+	return n, nil
+}
+
+// configure applies the settings in c to r.
+func (r *renderer) configure(c config) error {
+	r.filters = c["filters"]
+	var err error
+	r.filterTimeout, err = c.duration("", "filter-timeout", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if mode, ok := c[""]["emoji"]; ok {
+		if r.emoji, err = parseEmojiMode(mode); err != nil {
+			return err
+		}
+	}
+	if r.fold, err = c.bool("", "fold", false); err != nil {
+		return err
+	}
+	if r.figureNumbers, err = c.bool("", "figure-numbers", false); err != nil {
+		return err
+	}
+	if r.sectionNumbers, err = c.bool("", "section-numbers", false); err != nil {
+		return err
+	}
+	if r.sectionNumbersFrom, err = c.int("", "section-numbers-from", 1); err != nil {
+		return err
+	}
+	if r.sectionNumbersFrom < 1 || r.sectionNumbersFrom > 6 {
+		return fmt.Errorf("section-numbers-from: %d is not a heading level", r.sectionNumbersFrom)
+	}
+	if r.execution.timeout, err = c.duration("", "exec-timeout", 10*time.Second); err != nil {
+		return err
+	}
+	if r.execution.cache, err = c.duration("", "exec-cache", 24*time.Hour); err != nil {
+		return err
+	}
+	if env, ok := c[""]["exec-env"]; ok {
+		r.execution.env = strings.Split(env, ",")
+	}
+	r.execution.commands = c["exec"]
+	exts, err := c.extensions()
+	r.setExtensions(exts)
+	return err
+}
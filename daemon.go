@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// daemonChildEnv is set in the environment of the background process
+// started by the first `mdview -daemon` invocation.
+const daemonChildEnv = "MDVIEW_DAEMON_CHILD"
+
+// daemonRequest is what a client sends the daemon over its socket, one
+// JSON object per line. The daemon answers with a daemonResponse.
+type daemonRequest struct {
+	File string `json:"file"`
+	// Fragment is the location.fragment to open the document at.
+	Fragment string `json:"fragment,omitempty"`
+}
+
+type daemonResponse struct {
+	URL string `json:"url,omitempty"`
+	// Open is set when no preview tab is connected and the client should
+	// open URL itself.
+	Open bool `json:"open,omitempty"`
+	// Args are the options the daemon was started with, which it renders
+	// every document with.
+	Args  []string `json:"args,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// daemonArgs returns the arguments that start a daemon with the options
+// set in fs. Options that only matter to the mdview handing a file over are
+// left out, and files are passed as absolute paths.
+func daemonArgs(fs *flag.FlagSet) ([]string, error) {
+	var args []string
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "b", "bare", "d", "dark", "x", "xhtml", "footnote-popups", "emoji", "extensions", "fold", "figure-numbers", "section-numbers", "section-numbers-from", "citation-style", "strict", "idle":
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		case "var":
+			for _, kv := range *f.Value.(*varFlag) {
+				args = append(args, "-var="+kv)
+			}
+		case "config", "bibliography", "template":
+			abs, aerr := filepath.Abs(f.Value.String())
+			if aerr != nil {
+				err = aerr
+			}
+			args = append(args, "-"+f.Name+"="+abs)
+		}
+	})
+	return args, err
+}
+
+// socketPath returns the per-user socket the daemon listens on. Unless
+// $XDG_RUNTIME_DIR provides a private directory for it, it lives in a
+// directory of the temporary directory that only the user may enter, which
+// is created if need be and refused if anyone else owns or can enter it.
+func socketPath() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, fmt.Sprintf("mdview-%d.sock", os.Getuid())), nil
+	}
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("mdview-%d", os.Getuid()))
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() || !private(info) {
+		return "", fmt.Errorf("%s is not a private directory of the current user", dir)
+	}
+	return filepath.Join(dir, "mdview.sock"), nil
+}
+
+// openInDaemon hands filename to the running daemon, starting one with
+// args if there is none, and opens the browser at loc if no tab is already
+// showing a preview. It warns when the running daemon was started with
+// other options, which it keeps rendering with.
+func openInDaemon(filename string, loc location, args []string) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	sock, err := socketPath()
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		if err = startDaemon(args); err != nil {
+			return err
+		}
+		for i := 0; i < 50; i++ {
+			time.Sleep(100 * time.Millisecond)
+			if conn, err = net.Dial("unix", sock); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	defer conn.Close()
+
+	if err = json.NewEncoder(conn).Encode(daemonRequest{File: abs, Fragment: loc.fragment()}); err != nil {
+		return err
+	}
+	var resp daemonResponse
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if strings.Join(resp.Args, "\x00") != strings.Join(args, "\x00") {
+		fmt.Fprintf(os.Stderr, "mdview: the running daemon keeps the options it was started with (%s); use -no-daemon to apply these\n", strings.Join(resp.Args, " "))
+	}
+	if resp.Open {
+		return openURL(resp.URL)
+	}
+	return nil
+}
+
+// startDaemon launches a detached copy of mdview that runs the daemon.
+func startDaemon(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), daemonChildEnv+"=1")
+	detach(cmd)
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// daemon serves every document it is handed from one HTTP server, so that
+// an open preview tab can simply be pointed at the next one. Each directory
+// gets its own server mounted under /<n>/.
+type daemon struct {
+	r    *renderer
+	hub  *hub
+	addr string
+	// args are the options the daemon was started with.
+	args []string
+
+	mu      sync.Mutex
+	servers []*server
+	last    time.Time
+}
+
+// runDaemon serves documents handed over the socket until it has been idle,
+// with no requests and no open tabs, for the given duration. args are the
+// options r was set up with.
+func runDaemon(r *renderer, args []string, idle time.Duration) error {
+	sock, err := socketPath()
+	if err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		return errors.New("daemon already running")
+	}
+	os.Remove(sock)
+	ul, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sock)
+	os.Chmod(sock, 0600)
+
+	hl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	d := &daemon{r: r, hub: &hub{}, addr: hl.Addr().String(), args: args, last: time.Now()}
+	go http.Serve(hl, d)
+	go func() {
+		for {
+			conn, err := ul.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+
+	for {
+		time.Sleep(time.Second)
+		d.mu.Lock()
+		last := d.last
+		d.mu.Unlock()
+		if time.Since(last) > idle && !d.hub.busy() {
+			return ul.Close()
+		}
+	}
+}
+
+func (d *daemon) touch() {
+	d.mu.Lock()
+	d.last = time.Now()
+	d.mu.Unlock()
+}
+
+// handle answers a single client request.
+func (d *daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	d.touch()
+	var req daemonRequest
+	resp := daemonResponse{Args: d.args}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err == nil {
+		resp.URL, err = d.open(req.File)
+	}
+	if err == nil && req.Fragment != "" {
+		resp.URL += "#" + req.Fragment
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		// Switch the tabs already showing a preview, if any.
+		dat, _ := json.Marshal(map[string]string{"url": resp.URL})
+		resp.Open = !d.hub.broadcast(event{"navigate", string(dat)})
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// open mounts the directory containing file, if it isn't already, and
+// returns the URL that shows file.
+func (d *daemon) open(file string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, start, err := newServer(file, d.r, d.hub)
+	if err != nil {
+		return "", err
+	}
+	n := -1
+	for i, mounted := range d.servers {
+		if mounted.root == s.root {
+			n = i
+		}
+	}
+	if n < 0 {
+		n = len(d.servers)
+		s.prefix = "/" + strconv.Itoa(n+1)
+		d.servers = append(d.servers, s)
+	}
+	return "http://" + d.addr + d.servers[n].prefix + start, nil
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d.touch()
+	p := strings.TrimPrefix(req.URL.Path, "/")
+	n, err := strconv.Atoi(strings.SplitN(p, "/", 2)[0])
+	d.mu.Lock()
+	if err != nil || n < 1 || n > len(d.servers) {
+		d.mu.Unlock()
+		http.NotFound(w, req)
+		return
+	}
+	s := d.servers[n-1]
+	d.mu.Unlock()
+	http.StripPrefix(s.prefix, s).ServeHTTP(w, req)
+}
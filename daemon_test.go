@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDaemonArgs(t *testing.T) {
+	fs := flag.NewFlagSet("mdview", flag.ContinueOnError)
+	fs.Bool("dark", false, "")
+	fs.Bool("fold", false, "")
+	fs.String("o", "", "")
+	fs.String("config", "", "")
+	fs.String("section", "", "")
+	fs.Duration("idle", 10*time.Minute, "")
+	var vars varFlag
+	fs.Var(&vars, "var", "")
+	err := fs.Parse([]string{"-dark", "-o", "out.html", "-var", "a=1", "-config", "mdview.json", "-fold=false", "-var", "b=x=y", "-section", "Usage", "-idle", "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := filepath.Abs("mdview.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := daemonArgs(fs)
+	want := []string{"-config=" + config, "-dark=true", "-fold=false", "-idle=1m0s", "-var=a=1", "-var=b=x=y"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("daemonArgs = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes on Windows")
+	}
+	dir, err := ioutil.TempDir("", "mdview-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("TMPDIR", dir)
+	os.Setenv("XDG_RUNTIME_DIR", "")
+
+	private := filepath.Join(dir, fmt.Sprintf("mdview-%d", os.Getuid()))
+	if err := os.Mkdir(private, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if sock, err := socketPath(); err == nil {
+		t.Errorf("socketPath in a directory others can enter = %s, want an error", sock)
+	}
+	os.Remove(private)
+	if err := os.Symlink(dir, private); err != nil {
+		t.Fatal(err)
+	}
+	if sock, err := socketPath(); err == nil {
+		t.Errorf("socketPath through a symlink = %s, want an error", sock)
+	}
+	os.Remove(private)
+
+	sock, err := socketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(private, "mdview.sock"); sock != want {
+		t.Errorf("socketPath = %s, want %s", sock, want)
+	}
+	if info, err := os.Stat(private); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("socket directory mode = %v, %v, want 0700", info.Mode().Perm(), err)
+	}
+
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	if sock, err := socketPath(); err != nil || filepath.Dir(sock) != dir {
+		t.Errorf("socketPath with $XDG_RUNTIME_DIR = %s, %v, want it in %s", sock, err, dir)
+	}
+}
+
+func TestDaemonAnswersWithItsOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdview-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "doc.md")
+	if err := ioutil.WriteFile(file, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{r: newRenderer(false, false, false), hub: &hub{}, addr: "127.0.0.1:1234", args: []string{"-dark=true"}}
+	client, conn := net.Pipe()
+	defer client.Close()
+	go d.handle(conn)
+	if err := json.NewEncoder(client).Encode(daemonRequest{File: file, Fragment: "line=3"}); err != nil {
+		t.Fatal(err)
+	}
+	var resp daemonResponse
+	line, err := bufio.NewReader(client).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &resp)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := daemonResponse{URL: "http://127.0.0.1:1234/1/doc.md#line=3", Open: true, Args: []string{"-dark=true"}}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
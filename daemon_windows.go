@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// detach is a no-op on Windows, where child processes already outlive
+// the console that started them.
+func detach(cmd *exec.Cmd) {}
+
+// private reports true on Windows, where the temporary directory already
+// belongs to the user.
+func private(info os.FileInfo) bool { return true }
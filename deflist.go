@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func init() {
+	markdown.RegisterBlockRule(1050, ruleDefinitionList, []int{1100})
+}
+
+// definitionMarker returns the position of the ":" or "~" starting a
+// definition on line, or -1 if there is none.
+func definitionMarker(s *markdown.StateBlock, line int) int {
+	start, max := s.BMarks[line]+s.TShift[line], s.EMarks[line]
+	if start >= max || s.Src[start] != ':' && s.Src[start] != '~' {
+		return -1
+	}
+	pos := start + 1
+	for pos < max && (s.Src[pos] == ' ' || s.Src[pos] == '\t') {
+		pos++
+	}
+	if pos == start+1 || pos >= max {
+		return -1
+	}
+	return start + 1
+}
+
+// ruleDefinitionList parses definition lists in the syntax of PHP Markdown
+// Extra and Pandoc: one or more terms, each followed by definitions that
+// start with ":" and may continue on indented lines.
+//
+//	Term
+//	: Definition of the term,
+//	  which may have several paragraphs.
+//
+// It is a port of markdown-it-deflist. As the token set is fixed, the list
+// is written out as HTML blocks around the terms' inline content and the
+// definitions' blocks.
+func ruleDefinitionList(s *markdown.StateBlock, startLine, endLine int, silent bool) bool {
+	if !enabled(s.Md, extDefinitionLists) {
+		return false
+	}
+	if silent {
+		// Only a paragraph continuing a definition lazily can be ended by
+		// the next definition, which is then indented less than it.
+		return s.SCount[startLine] < s.BlkIndent && definitionMarker(s, startLine) >= 0
+	}
+	if s.SCount[startLine]-s.BlkIndent >= 4 {
+		return false
+	}
+
+	nextLine := startLine + 1
+	if nextLine >= endLine {
+		return false
+	}
+	if s.IsLineEmpty(nextLine) {
+		nextLine++
+		if nextLine >= endLine {
+			return false
+		}
+	}
+	if s.SCount[nextLine] < s.BlkIndent {
+		return false
+	}
+	contentStart := definitionMarker(s, nextLine)
+	if contentStart < 0 {
+		return false
+	}
+
+	first := len(s.Tokens)
+	level := s.Level
+	tight := true
+	dl := &markdown.HTMLBlock{Content: "<dl>\n", Map: [2]int{startLine, 0}}
+	s.PushToken(dl)
+
+	dtLine, ddLine := startLine, nextLine
+outer:
+	for {
+		prevEmptyEnd := false
+		s.PushToken(&markdown.HTMLBlock{Content: "<dt>", Map: [2]int{dtLine, dtLine + 1}})
+		s.PushToken(&markdown.Inline{
+			Content: strings.TrimSpace(s.Lines(dtLine, dtLine+1, s.BlkIndent, false)),
+			Map:     [2]int{dtLine, dtLine + 1},
+		})
+		s.PushToken(&markdown.HTMLBlock{Content: "</dt>\n"})
+
+		for {
+			dd := &markdown.HTMLBlock{Content: "<dd>\n", Map: [2]int{ddLine, 0}}
+			s.PushToken(dd)
+
+			pos, max := contentStart, s.EMarks[ddLine]
+			offset := s.SCount[ddLine] + contentStart - (s.BMarks[ddLine] + s.TShift[ddLine])
+		spaces:
+			for ; pos < max; pos++ {
+				switch s.Src[pos] {
+				case ' ':
+					offset++
+				case '\t':
+					offset += 4 - offset%4
+				default:
+					break spaces
+				}
+			}
+
+			oldTight, oldBlkIndent := s.Tight, s.BlkIndent
+			oldTShift, oldSCount := s.TShift[ddLine], s.SCount[ddLine]
+			s.BlkIndent = s.SCount[ddLine] + 2
+			s.TShift[ddLine] = pos - s.BMarks[ddLine]
+			s.SCount[ddLine] = offset
+			s.Tight = true
+			s.Md.Block.Tokenize(s, ddLine, endLine)
+			if !s.Tight || prevEmptyEnd {
+				tight = false
+			}
+			prevEmptyEnd = s.Line-ddLine > 1 && s.IsLineEmpty(s.Line-1)
+			s.Tight, s.BlkIndent = oldTight, oldBlkIndent
+			s.TShift[ddLine], s.SCount[ddLine] = oldTShift, oldSCount
+
+			s.PushToken(&markdown.HTMLBlock{Content: "</dd>\n"})
+			nextLine = s.Line
+			dd.Map[1] = nextLine
+
+			if nextLine >= endLine || s.SCount[nextLine] < s.BlkIndent {
+				break outer
+			}
+			if contentStart = definitionMarker(s, nextLine); contentStart < 0 {
+				break
+			}
+			ddLine = nextLine
+		}
+
+		// Another term, followed by its definitions, continues the list.
+		dtLine = nextLine
+		if s.IsLineEmpty(dtLine) || s.SCount[dtLine] < s.BlkIndent {
+			break
+		}
+		ddLine = dtLine + 1
+		if ddLine < endLine && s.IsLineEmpty(ddLine) {
+			ddLine++
+		}
+		if ddLine >= endLine || s.SCount[ddLine] < s.BlkIndent {
+			break
+		}
+		if contentStart = definitionMarker(s, ddLine); contentStart < 0 {
+			break
+		}
+	}
+
+	s.PushToken(&markdown.HTMLBlock{Content: "</dl>\n"})
+	dl.Map[1] = nextLine
+	s.Line = nextLine
+
+	if tight {
+		for _, tok := range s.Tokens[first:] {
+			switch tok := tok.(type) {
+			case *markdown.ParagraphOpen:
+				if tok.Lvl == level {
+					tok.Tight = true
+				}
+			case *markdown.ParagraphClose:
+				if tok.Lvl == level {
+					tok.Tight = true
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// diagramLanguages are the fence languages drawn as ASCII-art diagrams.
+var diagramLanguages = map[string]bool{"ascii": true, "svgbob": true, "bob": true}
+
+// diagrams replaces ASCII-art fences with SVG drawings. Fences that turn
+// out to hold no drawing are left as preformatted text.
+func (d *document) diagrams(t *theme) {
+	background := "#fff"
+	if t != nil {
+		background = t.background
+	}
+	for i, tok := range d.tokens {
+		fence, ok := tok.(*markdown.Fence)
+		if !ok {
+			continue
+		}
+		if fields := strings.Fields(fence.Params); len(fields) == 0 || !diagramLanguages[fields[0]] {
+			continue
+		}
+		if svg, ok := asciiDiagram(fence.Content, background); ok {
+			d.tokens[i] = &markdown.HTMLBlock{Content: `<div class="mdview-diagram">` + svg + "</div>\n", Map: fence.Map, Lvl: fence.Lvl}
+		}
+	}
+}
+
+// Each character of a diagram takes up a cell of cellWidth by cellHeight.
+const (
+	cellWidth  = 8
+	cellHeight = 16
+)
+
+// The directions a character may connect to its neighbours in.
+const (
+	up = 1 << iota
+	down
+	left
+	right
+)
+
+// boxArms are the connections of the Unicode box drawing characters.
+var boxArms = map[rune]int{
+	'─': left | right, '━': left | right, '═': left | right,
+	'│': up | down, '┃': up | down, '║': up | down,
+	'┌': right | down, '┐': left | down, '└': right | up, '┘': left | up,
+	'╔': right | down, '╗': left | down, '╚': right | up, '╝': left | up,
+	'╭': right | down, '╮': left | down, '╰': right | up, '╯': left | up,
+	'├': up | down | right, '┤': up | down | left, '┬': left | right | down, '┴': left | right | up,
+	'╠': up | down | right, '╣': up | down | left, '╦': left | right | down, '╩': left | right | up,
+	'┼': up | down | left | right, '╬': up | down | left | right,
+}
+
+// roundedBox are the box drawing characters with rounded corners.
+var roundedBox = map[rune]bool{'╭': true, '╮': true, '╰': true, '╯': true}
+
+type grid [][]rune
+
+func (g grid) at(x, y int) rune {
+	if y < 0 || y >= len(g) || x < 0 || x >= len(g[y]) {
+		return ' '
+	}
+	return g[y][x]
+}
+
+// potential returns the directions the character at x, y could connect in.
+func (g grid) potential(x, y int) int {
+	c := g.at(x, y)
+	if arms, ok := boxArms[c]; ok {
+		return arms
+	}
+	switch c {
+	case '-', '=':
+		return left | right
+	case '|':
+		return up | down
+	case '+':
+		return up | down | left | right
+	case '.', ',':
+		return left | right | down
+	case '\'', '`':
+		return left | right | up
+	case '*', 'o':
+		if isAlnum(g.at(x-1, y)) || isAlnum(g.at(x+1, y)) {
+			return 0 // part of a word
+		}
+		return up | down | left | right
+	case '<':
+		return right
+	case '>':
+		return left
+	case '^':
+		return down
+	case 'v', 'V':
+		if isAlnum(g.at(x-1, y)) || isAlnum(g.at(x+1, y)) {
+			return 0
+		}
+		return up
+	}
+	return 0
+}
+
+// arms returns the directions the character at x, y actually connects in:
+// those in which its neighbour can connect back.
+func (g grid) arms(x, y int) int {
+	p := g.potential(x, y)
+	arms := 0
+	if p&up != 0 && g.potential(x, y-1)&down != 0 {
+		arms |= up
+	}
+	if p&down != 0 && g.potential(x, y+1)&up != 0 {
+		arms |= down
+	}
+	if p&left != 0 && g.potential(x-1, y)&right != 0 {
+		arms |= left
+	}
+	if p&right != 0 && g.potential(x+1, y)&left != 0 {
+		arms |= right
+	}
+	return arms
+}
+
+func isAlnum(c rune) bool { return unicode.IsLetter(c) || unicode.IsDigit(c) }
+
+func bits(arms int) int {
+	n := 0
+	for ; arms != 0; arms &= arms - 1 {
+		n++
+	}
+	return n
+}
+
+// drawing collects the shapes of a diagram. Straight lines are kept apart
+// so that touching pieces can be joined into one.
+type drawing struct {
+	horizontal map[int][][2]int // y to x ranges
+	vertical   map[int][][2]int // x to y ranges
+	shapes     []string
+}
+
+func (d *drawing) hline(y, x1, x2 int) {
+	d.horizontal[y] = append(d.horizontal[y], [2]int{x1, x2})
+}
+
+func (d *drawing) vline(x, y1, y2 int) {
+	d.vertical[x] = append(d.vertical[x], [2]int{y1, y2})
+}
+
+func (d *drawing) shape(format string, args ...interface{}) {
+	d.shapes = append(d.shapes, fmt.Sprintf(format, args...))
+}
+
+// arms draws the lines from the centre of a cell to the given edges.
+func (d *drawing) arms(x, y, arms int) {
+	cx, cy := x*cellWidth+cellWidth/2, y*cellHeight+cellHeight/2
+	if arms&left != 0 {
+		d.hline(cy, x*cellWidth, cx)
+	}
+	if arms&right != 0 {
+		d.hline(cy, cx, x*cellWidth+cellWidth)
+	}
+	if arms&up != 0 {
+		d.vline(cx, y*cellHeight, cy)
+	}
+	if arms&down != 0 {
+		d.vline(cx, cy, y*cellHeight+cellHeight)
+	}
+}
+
+// corner draws a rounded corner joining each horizontal arm to each
+// vertical one.
+func (d *drawing) corner(x, y, arms int) {
+	cx, cy := x*cellWidth+cellWidth/2, y*cellHeight+cellHeight/2
+	const r = cellWidth / 2
+	for _, h := range []int{left, right} {
+		for _, v := range []int{up, down} {
+			if arms&h == 0 || arms&v == 0 {
+				continue
+			}
+			sx, ex := 1, x*cellWidth+cellWidth
+			if h == left {
+				sx, ex = -1, x*cellWidth
+			}
+			sy, ey := 1, y*cellHeight+cellHeight
+			if v == up {
+				sy, ey = -1, y*cellHeight
+			}
+			d.shape(`<path d="M%d %dL%d %dQ%d %d %d %dL%d %d"/>`, ex, cy, cx+sx*r, cy, cx, cy, cx, cy+sy*r, cx, ey)
+		}
+	}
+}
+
+// asciiDiagram draws the ASCII art in src as SVG: lines, boxes with square
+// or rounded corners, arrows and text. It reports false if src contains
+// no drawing at all.
+func asciiDiagram(src, background string) (string, bool) {
+	lines := strings.Split(strings.TrimRight(strings.Replace(src, "\t", "        ", -1), "\n"), "\n")
+	g := make(grid, len(lines))
+	width := 0
+	for y, line := range lines {
+		g[y] = []rune(strings.TrimRight(line, " "))
+		if len(g[y]) > width {
+			width = len(g[y])
+		}
+	}
+	if len(g) == 0 || width == 0 || len(g) > 1000 || width > 500 {
+		return "", false
+	}
+
+	dr := &drawing{horizontal: make(map[int][][2]int), vertical: make(map[int][][2]int)}
+	text := make([][]bool, len(g))
+	for y, row := range g {
+		text[y] = make([]bool, len(row))
+		for x, c := range row {
+			if c == ' ' {
+				continue
+			}
+			if !dr.cell(g, x, y, background) {
+				text[y][x] = true
+			}
+		}
+	}
+	if len(dr.horizontal) == 0 && len(dr.vertical) == 0 && len(dr.shapes) == 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" class="mdview-bob">`,
+		width*cellWidth, len(g)*cellHeight, width*cellWidth, len(g)*cellHeight)
+	b.WriteString(`<g fill="none" stroke="currentColor" stroke-width="1.5" stroke-linecap="round" stroke-linejoin="round">`)
+	for _, y := range sortedKeys(dr.horizontal) {
+		for _, r := range merge(dr.horizontal[y]) {
+			fmt.Fprintf(&b, `<path d="M%d %dH%d"/>`, r[0], y, r[1])
+		}
+	}
+	for _, x := range sortedKeys(dr.vertical) {
+		for _, r := range merge(dr.vertical[x]) {
+			fmt.Fprintf(&b, `<path d="M%d %dV%d"/>`, x, r[0], r[1])
+		}
+	}
+	b.WriteString(strings.Join(dr.shapes, ""))
+	b.WriteString(`</g><g fill="currentColor" font-family="monospace" font-size="13px">`)
+	for y, row := range g {
+		for x := 0; x < len(row); x++ {
+			if !text[y][x] {
+				continue
+			}
+			// A run of text, which may contain single spaces.
+			end := x + 1
+			for end < len(row) && (text[y][end] || row[end] == ' ' && end+1 < len(row) && text[y][end+1]) {
+				end++
+			}
+			fmt.Fprintf(&b, `<text x="%d" y="%d"`, x*cellWidth, y*cellHeight+cellHeight/2+4)
+			if end-x > 1 {
+				fmt.Fprintf(&b, ` textLength="%d"`, (end-x)*cellWidth)
+			}
+			b.WriteString(">" + html.EscapeString(string(row[x:end])) + "</text>")
+			x = end
+		}
+	}
+	b.WriteString("</g></svg>")
+	return b.String(), true
+}
+
+// cell draws the character at x, y, reporting false if it is text.
+func (d *drawing) cell(g grid, x, y int, background string) bool {
+	c := g.at(x, y)
+	cx, cy := x*cellWidth+cellWidth/2, y*cellHeight+cellHeight/2
+	left0, top := x*cellWidth, y*cellHeight
+	arms := g.arms(x, y)
+
+	if box, ok := boxArms[c]; ok {
+		if roundedBox[c] {
+			d.corner(x, y, box)
+		} else {
+			d.arms(x, y, box)
+		}
+		return true
+	}
+	switch c {
+	case '-', '=', '|':
+		if arms == 0 && (c != '|' || g.at(x-1, y) != '_' && g.at(x+1, y) != '_') {
+			return false
+		}
+		d.arms(x, y, g.potential(x, y))
+	case '+':
+		if bits(arms) < 2 {
+			return false
+		}
+		d.arms(x, y, arms)
+	case '.', ',', '\'', '`':
+		if bits(arms) < 2 {
+			return false
+		}
+		if arms&(up|down) == 0 {
+			d.arms(x, y, arms)
+		} else {
+			d.corner(x, y, arms)
+		}
+	case '*', 'o':
+		if arms == 0 {
+			return false
+		}
+		d.arms(x, y, arms)
+		if c == '*' {
+			d.shape(`<circle cx="%d" cy="%d" r="3" fill="currentColor"/>`, cx, cy)
+		} else {
+			d.shape(`<circle cx="%d" cy="%d" r="3.5" fill="%s"/>`, cx, cy, background)
+		}
+	case '>':
+		if arms == 0 {
+			return false
+		}
+		d.shape(`<path d="M%d %dL%d %dL%d %dz" fill="currentColor"/>`, left0, cy-4, left0+cellWidth, cy, left0, cy+4)
+	case '<':
+		if arms == 0 {
+			return false
+		}
+		d.shape(`<path d="M%d %dL%d %dL%d %dz" fill="currentColor"/>`, left0+cellWidth, cy-4, left0, cy, left0+cellWidth, cy+4)
+	case '^':
+		if arms == 0 {
+			return false
+		}
+		d.vline(cx, top+8, top+cellHeight)
+		d.shape(`<path d="M%d %dL%d %dL%d %dz" fill="currentColor"/>`, cx-4, top+8, cx, top, cx+4, top+8)
+	case 'v', 'V':
+		if arms == 0 {
+			return false
+		}
+		d.vline(cx, top, top+cellHeight-8)
+		d.shape(`<path d="M%d %dL%d %dL%d %dz" fill="currentColor"/>`, cx-4, top+cellHeight-8, cx, top+cellHeight, cx+4, top+cellHeight-8)
+	case '/':
+		if !diagonal(g.at(x+1, y-1), '/') && !diagonal(g.at(x-1, y+1), '/') {
+			return false
+		}
+		d.shape(`<path d="M%d %dL%d %d"/>`, left0, top+cellHeight, left0+cellWidth, top)
+	case '\\':
+		if !diagonal(g.at(x-1, y-1), '\\') && !diagonal(g.at(x+1, y+1), '\\') {
+			return false
+		}
+		d.shape(`<path d="M%d %dL%d %d"/>`, left0, top, left0+cellWidth, top+cellHeight)
+	case '_':
+		l, r := g.at(x-1, y), g.at(x+1, y)
+		if l != '_' && l != '|' && r != '_' && r != '|' {
+			return false
+		}
+		d.hline(top+cellHeight, left0, left0+cellWidth)
+	default:
+		return false
+	}
+	return true
+}
+
+// diagonal reports whether a diagonal line continues into c.
+func diagonal(c, line rune) bool {
+	return c == line || strings.ContainsRune("+.'*o", c)
+}
+
+func sortedKeys(m map[int][][2]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// merge joins overlapping and touching ranges.
+func merge(ranges [][2]int) [][2]int {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var out [][2]int
+	for _, r := range ranges {
+		if n := len(out); n > 0 && r[0] <= out[n-1][1] {
+			if r[1] > out[n-1][1] {
+				out[n-1][1] = r[1]
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+//go:generate go run gen_emoji.go
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// How emoji shortcodes are rendered, set by -emoji or the emoji setting.
+const (
+	emojiUnicode = "unicode" // the emoji character itself
+	emojiSpan    = "span"    // the character in a span styled like GitHub's
+	emojiNone    = "none"    // shortcodes are left as they are
+)
+
+// parseEmojiMode checks the value of -emoji or the emoji setting.
+func parseEmojiMode(mode string) (string, error) {
+	switch mode {
+	case emojiUnicode, emojiSpan, emojiNone:
+		return mode, nil
+	}
+	return "", fmt.Errorf("emoji: unknown mode %q, want unicode, span or none", mode)
+}
+
+// emoji replaces GitHub shortcodes such as :rocket: in the text with the
+// emoji they stand for. Code spans and code blocks are tokens of their own,
+// so shortcodes in them are left alone.
+func (d *document) emoji(mode string) {
+	if mode == emojiNone {
+		return
+	}
+	for _, tok := range d.tokens {
+		inline, ok := tok.(*markdown.Inline)
+		if !ok {
+			continue
+		}
+		var children []markdown.Token
+		for _, child := range inline.Children {
+			text, ok := child.(*markdown.Text)
+			if !ok || !strings.Contains(text.Content, ":") {
+				children = append(children, child)
+				continue
+			}
+			children = append(children, expandEmoji(text, mode)...)
+		}
+		inline.Children = children
+	}
+}
+
+// expandEmoji splits text around its shortcodes, which become spans or
+// are replaced in the text itself. Shortcodes must not touch a letter or
+// digit on either side, so that times such as 10:100:20 are left alone.
+func expandEmoji(text *markdown.Text, mode string) []markdown.Token {
+	var toks []markdown.Token
+	var b strings.Builder
+	s := text.Content
+	done := 0 // s[:done] has been written to b or toks
+	for i := 0; ; {
+		start := strings.IndexByte(s[i:], ':')
+		if start < 0 {
+			break
+		}
+		start += i
+		end := strings.IndexByte(s[start+1:], ':')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		name := s[start+1 : end]
+		e, ok := emojis[name]
+		if !ok || !emojiBoundary(s, start, end+1) {
+			// The closing colon may open the next shortcode.
+			i = end
+			continue
+		}
+		b.WriteString(s[done:start])
+		done, i = end+1, end+1
+		if mode == emojiSpan {
+			if b.Len() > 0 {
+				toks = append(toks, &markdown.Text{Content: b.String(), Lvl: text.Lvl})
+				b.Reset()
+			}
+			toks = append(toks, &markdown.HTMLInline{Content: `<span class="emoji" role="img" aria-label="` +
+				html.EscapeString(strings.Replace(name, "_", " ", -1)) + `" title=":` + html.EscapeString(name) + `:">` + e + "</span>"})
+		} else {
+			b.WriteString(e)
+		}
+	}
+	s = s[done:]
+	b.WriteString(s)
+	if b.Len() > 0 || len(toks) == 0 {
+		text.Content = b.String()
+		toks = append(toks, text)
+	}
+	return toks
+}
+
+// emojiBoundary reports whether the shortcode s[start:end] stands apart
+// from the words around it.
+func emojiBoundary(s string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(s[:start])
+	after, _ := utf8.DecodeRuneInString(s[end:])
+	return !wordRune(before) && !wordRune(after)
+}
+
+func wordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// emojiTitle replaces the shortcodes in a page title, which is plain text.
+func emojiTitle(title string) string {
+	return expandEmoji(&markdown.Text{Content: title}, emojiUnicode)[0].(*markdown.Text).Content
+}
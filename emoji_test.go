@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func TestExpandEmoji(t *testing.T) {
+	smile, hundred := emojis["smile"], emojis["100"]
+	tests := []struct {
+		text, want string
+	}{
+		{":smile:", smile},
+		{"hi :smile: there", "hi " + smile + " there"},
+		{"(:smile:)", "(" + smile + ")"},
+		{":smile::100:", smile + hundred},
+		{"done:smile:", "done:smile:"},
+		{"a:smile:b", "a:smile:b"},
+		{"10:100:20", "10:100:20"},
+		{"at 10:30: :smile:", "at 10:30: " + smile},
+		{":nope: :smile:", ":nope: " + smile},
+		{"é:smile:", "é:smile:"},
+	}
+	for _, tt := range tests {
+		toks := expandEmoji(&markdown.Text{Content: tt.text}, emojiUnicode)
+		if len(toks) != 1 || toks[0].(*markdown.Text).Content != tt.want {
+			t.Errorf("expandEmoji(%q) = %v, want %q", tt.text, toks, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// execCommands maps the languages of {exec} fences to the interpreters
+// their code is piped to. The [exec] section of the configuration adds to
+// them.
+var execCommands = map[string]string{
+	"sh":         "sh",
+	"shell":      "sh",
+	"bash":       "bash",
+	"zsh":        "zsh",
+	"python":     "python3",
+	"py":         "python3",
+	"ruby":       "ruby",
+	"rb":         "ruby",
+	"perl":       "perl",
+	"js":         "node",
+	"javascript": "node",
+	"node":       "node",
+	"powershell": "pwsh -Command -",
+	"pwsh":       "pwsh -Command -",
+}
+
+// defaultExecEnv lists the environment variables {exec} fences see unless
+// the configuration says otherwise.
+var defaultExecEnv = []string{"PATH", "HOME", "LANG", "TMPDIR", "SYSTEMROOT", "TEMP", "TMP", "USERPROFILE"}
+
+// execution holds the settings for running {exec} fences.
+type execution struct {
+	commands map[string]string
+	env      []string
+	timeout  time.Duration
+	// cache is how long output is reused for before the code runs again.
+	cache time.Duration
+}
+
+// execute runs the code of fences marked {exec}, as in
+// ```` ```sh {exec cwd=examples timeout=30s} ````, showing what it writes to
+// stdout and stderr in a block beneath. Code runs in the directory of the
+// document name, or cwd relative to it, and sees only the environment
+// variables e.env and the fence's env option list. Output is cached by
+// interpreter, directory, environment and code for e.cache, or for the
+// fence's cache option, such as cache=1h, unless that is cache=false. It
+// returns the errors of fences that failed.
+func (d *document) execute(name string, e *execution) []string {
+	var errs []string
+	for i := 0; i < len(d.tokens); i++ {
+		fence, ok := d.tokens[i].(*markdown.Fence)
+		if !ok {
+			continue
+		}
+		lang, opts, ok := execOptions(fence.Params)
+		if !ok {
+			continue
+		}
+		fence.Params = lang
+
+		out, err := e.run(name, lang, opts, fence.Content)
+		var content string
+		if len(out) > 0 || err == nil {
+			content = `<pre class="mdview-exec-output"><code>` + html.EscapeString(string(out)) + "</code></pre>\n"
+		}
+		if err != nil {
+			errs = append(errs, filepath.Base(name)+":"+strconv.Itoa(fence.Map[0]+1)+": "+err.Error())
+			content += `<pre class="mdview-filter-error">` + html.EscapeString(err.Error()) + "</pre>\n"
+		}
+		d.insert(i+1, &markdown.HTMLBlock{Content: content, Lvl: fence.Lvl})
+		i++
+	}
+	return errs
+}
+
+// execOptions reports whether a fence's info string, such as
+// "sh {exec timeout=5s}", marks it for running, returning its language and
+// options.
+func execOptions(params string) (string, map[string]string, bool) {
+	params = strings.TrimSpace(params)
+	open := strings.IndexByte(params, '{')
+	if open < 0 || !strings.HasSuffix(params, "}") {
+		return "", nil, false
+	}
+	word, opts := fenceOptions(params[open+1 : len(params)-1])
+	if word != "exec" {
+		return "", nil, false
+	}
+	return strings.TrimSpace(params[:open]), opts, true
+}
+
+// run pipes code to the interpreter for lang, returning its output.
+func (e *execution) run(name, lang string, opts map[string]string, code string) ([]byte, error) {
+	command, ok := e.commands[lang]
+	if !ok {
+		command, ok = execCommands[lang]
+	}
+	args := splitCommand(command)
+	if !ok || len(args) == 0 {
+		return nil, errors.New("no interpreter for " + strings.TrimSpace(lang+" code"))
+	}
+
+	dir := filepath.Dir(name)
+	if cwd := opts["cwd"]; cwd != "" {
+		if filepath.IsAbs(cwd) {
+			dir = cwd
+		} else {
+			dir = filepath.Join(dir, filepath.FromSlash(cwd))
+		}
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	timeout := e.timeout
+	if t := opts["timeout"]; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, err
+		}
+		timeout = d
+	}
+	ttl := e.cache
+	switch c := opts["cache"]; c {
+	case "", "true":
+	case "false":
+		ttl = 0
+	default:
+		d, err := time.ParseDuration(c)
+		if err != nil {
+			return nil, err
+		}
+		ttl = d
+	}
+	// A nil environment would be the whole of mdview's.
+	env := []string{}
+	allowed := e.env
+	if list := opts["env"]; list != "" {
+		allowed = append(allowed[:len(allowed):len(allowed)], strings.Split(list, ",")...)
+	}
+	for _, k := range allowed {
+		if v, ok := os.LookupEnv(strings.TrimSpace(k)); ok {
+			env = append(env, strings.TrimSpace(k)+"="+v)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(command + "\x00" + dir + "\x00" + strings.Join(env, "\x00") + "\x00" + code))
+	cache := ""
+	if ttl > 0 {
+		cache = cachePath("exec", hex.EncodeToString(sum[:]))
+	}
+	if cache != "" {
+		if info, err := os.Stat(cache); err == nil && time.Since(info.ModTime()) < ttl {
+			if dat, err := ioutil.ReadFile(cache); err == nil {
+				return dat, nil
+			}
+		}
+	}
+
+	// The output goes to a file rather than a pipe, so that processes the
+	// code leaves running in the background cannot hold up the render.
+	f, err := ioutil.TempFile("", "mdview-exec")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir, cmd.Env = dir, env
+	cmd.Stdin = strings.NewReader(code)
+	cmd.Stdout, cmd.Stderr = f, f
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.New("timed out after " + timeout.String())
+	}
+	out, rerr := ioutil.ReadFile(f.Name())
+	if rerr != nil && err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return out, errors.New(lang + ": " + err.Error())
+	}
+
+	if cache != "" {
+		if os.MkdirAll(filepath.Dir(cache), 0755) == nil {
+			ioutil.WriteFile(cache, out, 0644)
+		}
+	}
+	return out, nil
+}
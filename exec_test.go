@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecCache(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	dir, err := ioutil.TempDir("", "mdview-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	if !strings.HasPrefix(cachePath("exec", "00"), dir) {
+		t.Skip("the cache directory does not follow $XDG_CACHE_HOME here")
+	}
+
+	e := &execution{env: []string{"PATH"}, timeout: 10 * time.Second, cache: time.Hour}
+	name := filepath.Join(dir, "doc.md")
+	const code = "echo run >> runs; wc -l < runs\n"
+	sum := sha256.Sum256([]byte("sh\x00" + dir + "\x00" + "PATH=" + os.Getenv("PATH") + "\x00" + code))
+	cached := cachePath("exec", hex.EncodeToString(sum[:]))
+	tests := []struct {
+		cache string
+		age   time.Duration
+		want  string
+	}{
+		{want: "1"},
+		{want: "1"},
+		{cache: "false", want: "2"},
+		{age: 2 * time.Hour, want: "3"},
+		{cache: "3h", age: 2 * time.Hour, want: "3"},
+		{cache: "1h", age: 2 * time.Hour, want: "4"},
+	}
+	for i, tt := range tests {
+		if tt.age > 0 {
+			old := time.Now().Add(-tt.age)
+			if err := os.Chtimes(cached, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		opts := map[string]string{}
+		if tt.cache != "" {
+			opts["cache"] = tt.cache
+		}
+		out, err := e.run(name, "sh", opts, code)
+		if got := strings.TrimSpace(string(out)); err != nil || got != tt.want {
+			t.Errorf("run %d (cache=%q, cached output %v old) = %q, %v, want %q", i+1, tt.cache, tt.age, got, err, tt.want)
+		}
+	}
+	if _, err := e.run(name, "sh", map[string]string{"cache": "often"}, code); err == nil {
+		t.Error("run with cache=often: no error")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// extension is a piece of optional markdown syntax, off unless enabled by
+// -extensions or the [extensions] section of the configuration file.
+type extension uint
+
+const (
+	extDefinitionLists extension = 1 << iota
+	extAbbreviations
+	extSubscript
+	extSuperscript
+	extHighlight
+
+	allExtensions = extDefinitionLists | extAbbreviations | extSubscript | extSuperscript | extHighlight
+)
+
+var extensionNames = map[string]extension{
+	"definition-lists": extDefinitionLists,
+	"abbreviations":    extAbbreviations,
+	"subscript":        extSubscript,
+	"superscript":      extSuperscript,
+	"highlight":        extHighlight,
+}
+
+// enabledExtensions maps each parser to the extensions enabled for it.
+// Rules are registered for every parser, so the ones for optional syntax
+// check here whether they apply.
+var enabledExtensions sync.Map
+
+// enabled reports whether ext is enabled for md.
+func enabled(md *markdown.Markdown, ext extension) bool {
+	exts, _ := enabledExtensions.Load(md)
+	return exts != nil && exts.(extension)&ext != 0
+}
+
+// setExtensions enables exts, and only exts, for r.
+func (r *renderer) setExtensions(exts extension) {
+	enabledExtensions.Store(r.md, exts)
+}
+
+// parseExtensions parses the comma separated extension names given to
+// -extensions. "all" enables every extension and "none" none of them.
+func parseExtensions(list string) (extension, error) {
+	var exts extension
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "none":
+			continue
+		case "all":
+			exts |= allExtensions
+			continue
+		}
+		ext, ok := extensionNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown extension %q", name)
+		}
+		exts |= ext
+	}
+	return exts, nil
+}
+
+// extensions returns the extensions switched on in c's [extensions]
+// section, such as
+//
+//	[extensions]
+//	definition-lists = true
+//	highlight = true
+func (c config) extensions() (extension, error) {
+	var exts extension
+	for name, value := range c["extensions"] {
+		ext, ok := extensionNames[name]
+		if !ok {
+			return 0, fmt.Errorf("extensions: unknown extension %q", name)
+		}
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return 0, fmt.Errorf("extensions: %s: %v", name, err)
+		}
+		if on {
+			exts |= ext
+		}
+	}
+	return exts, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"html"
+	"strconv"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// figures renders paragraphs holding nothing but an image with a title as
+// figures captioned by the title, numbered "Figure 1", "Figure 2" and so on
+// if number is set. An attribute block such as {width=400 .center #arch}
+// right after an image sets its attributes; the id and classes go to the
+// figure when there is one.
+func (d *document) figures(number, xhtml bool) {
+	n := 0
+	for i := 0; i < len(d.tokens); i++ {
+		inline, ok := d.tokens[i].(*markdown.Inline)
+		if !ok {
+			continue
+		}
+		if img, attrs, ok := figureImage(inline); ok && i > 0 && i+1 < len(d.tokens) {
+			if open, ok := d.tokens[i-1].(*markdown.ParagraphOpen); ok {
+				n++
+				content, id, ref := figureHTML(img, attrs, n, number, xhtml)
+				if id != "" {
+					d.addTarget(id, ref)
+				}
+				d.tokens[i-1] = &markdown.HTMLBlock{Content: content, Map: open.Map, Lvl: open.Lvl}
+				d.tokens = append(d.tokens[:i], d.tokens[i+2:]...)
+				i--
+				continue
+			}
+		}
+
+		var children []markdown.Token
+		for j := 0; j < len(inline.Children); j++ {
+			img, ok := inline.Children[j].(*markdown.Image)
+			if !ok || j+1 >= len(inline.Children) {
+				children = append(children, inline.Children[j])
+				continue
+			}
+			text, ok := inline.Children[j+1].(*markdown.Text)
+			if !ok {
+				children = append(children, img)
+				continue
+			}
+			attrs, end := parseAttributes(text.Content)
+			if end < 0 {
+				children = append(children, img)
+				continue
+			}
+			children = append(children, &markdown.HTMLInline{Content: imageHTML(img, attrs, true, xhtml)})
+			if text.Content = text.Content[end:]; text.Content == "" {
+				j++
+			}
+		}
+		inline.Children = children
+	}
+}
+
+// figureImage returns the image inline consists of, along with the
+// attributes following it, if it has a title to caption a figure with.
+func figureImage(inline *markdown.Inline) (*markdown.Image, []attribute, bool) {
+	children := inline.Children
+	if len(children) == 0 || len(children) > 2 {
+		return nil, nil, false
+	}
+	img, ok := children[0].(*markdown.Image)
+	if !ok || img.Title == "" {
+		return nil, nil, false
+	}
+	if len(children) == 1 {
+		return img, nil, true
+	}
+	text, ok := children[1].(*markdown.Text)
+	if !ok {
+		return nil, nil, false
+	}
+	attrs, end := parseAttributes(text.Content)
+	if end < 0 || strings.TrimSpace(text.Content[end:]) != "" {
+		return nil, nil, false
+	}
+	return img, attrs, true
+}
+
+// figureHTML renders img as the nth figure of the document, returning the
+// figure's id, if any, and the text of references to it.
+func figureHTML(img *markdown.Image, attrs []attribute, n int, number, xhtml bool) (string, string, string) {
+	var figAttrs, imgAttrs []attribute
+	for _, a := range attrs {
+		if a.name == "id" || a.name == "class" {
+			figAttrs = append(figAttrs, a)
+		} else {
+			imgAttrs = append(imgAttrs, a)
+		}
+	}
+	caption, ref := html.EscapeString(img.Title), img.Title
+	if number {
+		if !hasAttr(figAttrs, "id") {
+			figAttrs = append(figAttrs, attribute{"id", "figure-" + strconv.Itoa(n)})
+		}
+		ref = "Figure " + strconv.Itoa(n)
+		caption = `<span class="figure-number">` + ref + ":</span> " + caption
+	}
+	var id string
+	for _, a := range figAttrs {
+		if a.name == "id" {
+			id = a.value
+		}
+	}
+	return "<figure" + formatAttrs(figAttrs) + ">\n" + imageHTML(img, imgAttrs, false, xhtml) +
+		"\n<figcaption>" + caption + "</figcaption>\n</figure>\n", id, ref
+}
+
+// imageHTML renders img with attrs, which override its alt text and title.
+// The title is left out unless title is set, as figures show it as their
+// caption.
+func imageHTML(img *markdown.Image, attrs []attribute, title, xhtml bool) string {
+	own := []attribute{{"src", img.Src}, {"alt", altText(img.Tokens)}}
+	if title && img.Title != "" {
+		own = append(own, attribute{"title", img.Title})
+	}
+	for _, a := range attrs {
+		own = setAttribute(own, a.name, a.value)
+	}
+	s := "<img" + formatAttrs(own)
+	if xhtml {
+		return s + " />"
+	}
+	return s + ">"
+}
+
+// altText flattens an image description to the plain text of its alt
+// attribute.
+func altText(toks []markdown.Token) string {
+	var b strings.Builder
+	for _, tok := range toks {
+		switch tok := tok.(type) {
+		case *markdown.Text:
+			b.WriteString(tok.Content)
+		case *markdown.CodeInline:
+			b.WriteString(tok.Content)
+		case *markdown.Image:
+			b.WriteString(altText(tok.Tokens))
+		case *markdown.Softbreak, *markdown.Hardbreak:
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// setAttribute sets the attribute name in attrs to value.
+func setAttribute(attrs []attribute, name, value string) []attribute {
+	for i, a := range attrs {
+		if a.name == name {
+			attrs[i].value = value
+			return attrs
+		}
+	}
+	return append(attrs, attribute{name, value})
+}
+
+func hasAttr(attrs []attribute, name string) bool {
+	for _, a := range attrs {
+		if a.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAttributes parses the attribute block, such as
+// {width=400 .center #fig-arch title="An example"}, at the start of s,
+// returning the attributes and the block's length, or -1 if s does not
+// start with one. Values may be quoted with the curly quotes the
+// typographer turns straight ones into.
+func parseAttributes(s string) ([]attribute, int) {
+	if !strings.HasPrefix(s, "{") {
+		return nil, -1
+	}
+	var attrs []attribute
+	var classes []string
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, -1
+		}
+		if s[i] == '}' {
+			break
+		}
+		start := i
+		for i < len(s) && !strings.ContainsRune(" \t\n}=", rune(s[i])) {
+			i++
+		}
+		name := s[start:i]
+		switch {
+		case len(name) > 1 && name[0] == '#':
+			attrs = setAttribute(attrs, "id", name[1:])
+		case len(name) > 1 && name[0] == '.':
+			classes = append(classes, name[1:])
+		case i < len(s) && s[i] == '=' && isAttributeName(name):
+			value, n := attributeValue(s[i+1:])
+			if n < 0 {
+				return nil, -1
+			}
+			attrs = setAttribute(attrs, strings.ToLower(name), value)
+			i += 1 + n
+		default:
+			return nil, -1
+		}
+	}
+	if len(classes) > 0 {
+		attrs = setAttribute(attrs, "class", strings.Join(classes, " "))
+	}
+	return attrs, i + 1
+}
+
+// attributeValue parses the value at the start of s, returning it and its
+// length in s.
+func attributeValue(s string) (string, int) {
+	for _, q := range [][2]string{{`"`, `"`}, {"'", "'"}, {"“", "”"}, {"‘", "’"}} {
+		if strings.HasPrefix(s, q[0]) {
+			end := strings.Index(s[len(q[0]):], q[1])
+			if end < 0 {
+				return "", -1
+			}
+			return s[len(q[0]) : len(q[0])+end], len(q[0]) + end + len(q[1])
+		}
+	}
+	end := strings.IndexAny(s, " \t\n}")
+	if end <= 0 {
+		return "", -1
+	}
+	return s[:end], end
+}
+
+// isAttributeName reports whether name may be set in an attribute block.
+// The image's source cannot be replaced, nor event handlers added.
+func isAttributeName(name string) bool {
+	if name == "" || strings.EqualFold(name, "src") || strings.HasPrefix(strings.ToLower(name), "on") {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; !isAlphanumeric(c) && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// filters replaces fenced code blocks whose language has a filter command
+// in the configuration with the command's output: the block's content is
+// piped to the command, and SVG or image output is embedded in the page.
+// Output is cached by command and content. Failed commands leave the code
+// in place, preceded by the error and whatever the command wrote to
+// stderr.
+func (d *document) filters(commands map[string]string, timeout time.Duration, xhtml bool) {
+	if len(commands) == 0 {
+		return
+	}
+	for i, tok := range d.tokens {
+		fence, ok := tok.(*markdown.Fence)
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(fence.Params)
+		if len(fields) == 0 {
+			continue
+		}
+		command, ok := commands[fields[0]]
+		if !ok {
+			continue
+		}
+		var out string
+		if dat, err := runFilter(command, fence.Content, timeout); err != nil {
+			out = `<pre class="mdview-filter-error">` + html.EscapeString(err.Error()) + "</pre>\n" +
+				"<pre><code>" + html.EscapeString(fence.Content) + "</code></pre>\n"
+		} else {
+			out = `<div class="mdview-filter">` + embed(dat, xhtml) + "</div>\n"
+		}
+		d.tokens[i] = &markdown.HTMLBlock{Content: out, Map: fence.Map, Lvl: fence.Lvl}
+	}
+}
+
+// filterError describes a failed filter command, including its stderr.
+type filterError struct {
+	command string
+	err     error
+	stderr  string
+}
+
+func (e *filterError) Error() string {
+	msg := e.command + ": " + e.err.Error()
+	if s := strings.TrimSpace(e.stderr); s != "" {
+		msg += "\n" + s
+	}
+	return msg
+}
+
+// runFilter pipes input to command, returning its output from the cache if
+// it has been run on the same input before.
+func runFilter(command, input string, timeout time.Duration) ([]byte, error) {
+	sum := sha256.Sum256([]byte(command + "\x00" + input))
+	cache := cachePath("filters", hex.EncodeToString(sum[:]))
+	if cache != "" {
+		if dat, err := ioutil.ReadFile(cache); err == nil {
+			return dat, nil
+		}
+	}
+
+	args := splitCommand(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty filter command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.New("timed out after " + timeout.String())
+	}
+	if err != nil {
+		return nil, &filterError{command, err, stderr.String()}
+	}
+
+	if cache != "" {
+		if os.MkdirAll(filepath.Dir(cache), 0755) == nil {
+			ioutil.WriteFile(cache, stdout.Bytes(), 0644)
+		}
+	}
+	return stdout.Bytes(), nil
+}
+
+// cachePath returns the file caching the output of a filter or {exec}
+// fence, as given by kind, with the given hash.
+func cachePath(kind, hash string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mdview", kind, hash[:2], hash)
+}
+
+var svgStart = regexp.MustCompile(`(?i)<svg[\s>]`)
+
+// embed renders a filter's output: SVG inline, other images as data URLs
+// and anything else as text.
+func embed(dat []byte, xhtml bool) string {
+	if loc := svgStart.FindIndex(dat); loc != nil {
+		// Drop the XML declaration, doctype and comments before the
+		// <svg> element, which are not allowed inside HTML.
+		return string(dat[loc[0]:])
+	}
+	if typ := http.DetectContentType(dat); strings.HasPrefix(typ, "image/") {
+		img := `<img src="data:` + typ + ";base64," + base64.StdEncoding.EncodeToString(dat) + `" alt=""`
+		if xhtml {
+			return img + " />"
+		}
+		return img + ">"
+	}
+	return "<pre>" + html.EscapeString(string(dat)) + "</pre>"
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func init() {
+	markdown.RegisterBlockRule(360, ruleDetails, []int{1100, 700, 400, 600})
+}
+
+// ruleDetails parses collapsible sections such as
+//
+//	+++ Summary shown while collapsed
+//	Contents, which may contain any markdown.
+//	+++
+//
+// into a <details> element. Like ::: containers, they nest when the outer
+// one is fenced by more plus signs. "+++>" instead of "+++" starts the
+// section expanded.
+func ruleDetails(s *markdown.StateBlock, startLine, endLine int, silent bool) bool {
+	if s.SCount[startLine]-s.BlkIndent >= 4 {
+		return false
+	}
+	line := s.Src[s.BMarks[startLine]+s.TShift[startLine] : s.EMarks[startLine]]
+	if !strings.HasPrefix(line, "+++") {
+		return false
+	}
+	summary := strings.TrimLeft(line, "+")
+	pluses := len(line) - len(summary)
+	open := strings.HasPrefix(summary, ">")
+	if open {
+		summary = summary[1:]
+	}
+	if summary != "" && summary[0] != ' ' && summary[0] != '\t' {
+		return false
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return false
+	}
+	if silent {
+		return true
+	}
+
+	nextLine := startLine
+	closed := false
+	for {
+		nextLine++
+		if nextLine >= endLine {
+			break
+		}
+		pos, max := s.BMarks[nextLine]+s.TShift[nextLine], s.EMarks[nextLine]
+		if pos < max && s.SCount[nextLine] < s.BlkIndent {
+			break
+		}
+		text := strings.TrimSpace(s.Src[pos:max])
+		if s.SCount[nextLine]-s.BlkIndent < 4 && len(text) >= pluses && strings.Trim(text, "+") == "" {
+			closed = true
+			break
+		}
+	}
+
+	details := "<details>\n"
+	if open {
+		details = "<details open=\"open\">\n"
+	}
+	s.PushToken(&markdown.HTMLBlock{Content: details + "<summary>", Map: [2]int{startLine, nextLine}})
+	s.PushToken(&markdown.Inline{Content: summary, Map: [2]int{startLine, startLine + 1}})
+	s.PushToken(&markdown.HTMLBlock{Content: "</summary>\n"})
+	oldLineMax := s.LineMax
+	s.LineMax = nextLine
+	s.Md.Block.Tokenize(s, startLine+1, nextLine)
+	s.LineMax = oldLineMax
+	s.PushToken(&markdown.HTMLBlock{Content: "</details>\n"})
+
+	s.Line = nextLine
+	if closed {
+		s.Line++
+	}
+	return true
+}
+
+// foldScript returns the script that lets every section of the page be
+// folded away under its heading, with controls to expand or collapse all
+// of them. Folded sections are unfolded again when a link leads into them,
+// and remembered in local storage under key, unless the preview server's
+// script, which comes later in the page, provides a window.mdviewFoldStore
+// to keep them in.
+func foldScript(key string) string {
+	dat, _ := json.Marshal(key)
+	k := string(dat)
+	return `<script>(function(){
+function init(){
+var body=document.querySelector(".markdown-body");if(!body)return;
+var key="mdview-fold:"+` + k + `,store=window.mdviewFoldStore,folded={},heads=[];
+if(store)folded=store.folded||{};else try{folded=JSON.parse(localStorage.getItem(key))||{}}catch(e){}
+function save(){if(store)store.save(folded);else try{localStorage.setItem(key,JSON.stringify(folded))}catch(e){}}
+function level(el){var m=/^h([1-6])$/i.exec(el.tagName);return m?+m[1]:7}
+for(var el=body.firstElementChild;el;el=el.nextElementSibling)if(level(el)<7&&el.id)heads.push(el);
+if(!heads.length)return;
+function update(){
+var hide=7;
+for(var el=body.firstElementChild;el;el=el.nextElementSibling){
+if(el.className==="mdview-fold-controls")continue;
+var l=level(el);if(l<=hide)hide=7;
+el.style.display=hide<7?"none":"";
+if(l<7&&el.id){var f=!!folded[el.id];el.classList.toggle("mdview-folded",f);
+var b=el.querySelector(".mdview-fold");if(b)b.setAttribute("aria-expanded",String(!f));if(f&&hide===7)hide=l}}}
+function change(){update();save()}
+function button(text,click){var b=document.createElement("button");b.type="button";b.textContent=text;b.addEventListener("click",click);return b}
+heads.forEach(function(h){var b=button("",function(){if(folded[h.id])delete folded[h.id];else folded[h.id]=true;change()});
+b.className="mdview-fold";b.title="Fold or unfold this section";h.insertBefore(b,h.firstChild)});
+var controls=document.createElement("div");controls.className="mdview-fold-controls";
+controls.appendChild(button("Expand all",function(){folded={};change()}));
+controls.appendChild(button("Collapse all",function(){heads.forEach(function(h){folded[h.id]=true});change()}));
+body.insertBefore(controls,body.firstChild);
+function reveal(){
+var el=location.hash?document.getElementById(decodeURIComponent(location.hash.substring(1))):null;
+while(el&&el.parentNode!==body)el=el.parentNode;if(!el)return;
+var lim=level(el),opened=false;
+for(el=el.previousElementSibling;el;el=el.previousElementSibling){var l=level(el);if(l<lim){opened=opened||!!folded[el.id];delete folded[el.id];lim=l}}
+if(opened)change()}
+update();window.addEventListener("load",reveal);window.addEventListener("hashchange",reveal)}
+if(document.readyState==="loading")document.addEventListener("DOMContentLoaded",init);else init();
+})();</script>`
+}
+
+// foldStatePath returns the file that the sections folded in the served
+// document name are kept in, or "" if there is no cache directory.
+func foldStatePath(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return cachePath("fold", hex.EncodeToString(sum[:]))
+}
+
+// loadFoldState returns the sections folded in the served document name as
+// a JSON object of heading ids, or null if there are none.
+func loadFoldState(name string) string {
+	var folded map[string]bool
+	dat, err := ioutil.ReadFile(foldStatePath(name))
+	if err != nil || json.Unmarshal(dat, &folded) != nil || folded == nil {
+		return "null"
+	}
+	dat, _ = json.Marshal(folded)
+	return string(dat)
+}
+
+// saveFoldState remembers the sections folded in the served document name.
+func saveFoldState(name string, folded map[string]bool) error {
+	p := foldStatePath(name)
+	if p == "" {
+		return errors.New("no cache directory to keep folded sections in")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	dat, _ := json.Marshal(folded)
+	return ioutil.WriteFile(p, dat, 0644)
+}
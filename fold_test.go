@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFoldEndpoint(t *testing.T) {
+	cache, err := ioutil.TempDir("", "mdview-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", cache)
+	if !strings.HasPrefix(foldStatePath("doc.md"), cache) {
+		t.Skip("the cache directory does not follow $XDG_CACHE_HOME here")
+	}
+
+	s, cleanup := testServer(t)
+	defer cleanup()
+	s.hub = &hub{}
+	doc := filepath.Join(s.root, "doc.md")
+	if err := ioutil.WriteFile(doc, []byte("# One\n\n## Two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadFoldState(doc); got != "null" {
+		t.Fatalf("folded sections before any were saved = %s, want null", got)
+	}
+	tests := []struct {
+		header, body string
+		code         int
+		want         string
+	}{
+		{header: "1", body: `{"path":"/doc.md","folded":{"two":true}}`, code: http.StatusNoContent, want: `{"two":true}`},
+		{body: `{"path":"/doc.md","folded":{}}`, code: http.StatusForbidden, want: `{"two":true}`},
+		{header: "1", body: `{"path":"/a.txt","folded":{}}`, code: http.StatusNotFound, want: `{"two":true}`},
+		{header: "1", body: `{"path":"/doc.md","folded":[]}`, code: http.StatusBadRequest, want: `{"two":true}`},
+		{header: "1", body: `{"path":"/doc.md","folded":{}}`, code: http.StatusNoContent, want: `{}`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "http://localhost"+apiPrefix+"fold", strings.NewReader(tt.body))
+		if tt.header != "" {
+			req.Header.Set("X-Mdview", tt.header)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("POST fold %s: status %d, want %d", tt.body, w.Code, tt.code)
+		}
+		if got := loadFoldState(doc); got != tt.want {
+			t.Errorf("after POST fold %s: folded sections = %s, want %s", tt.body, got, tt.want)
+		}
+	}
+
+	s.r.fold = true
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/doc.md", nil))
+	if body := w.Body.String(); !strings.Contains(body, "window.mdviewFoldStore={folded:{}") {
+		t.Errorf("served page does not hand the fold script its folded sections:\n%s", body)
+	}
+}
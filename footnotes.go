@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// The footnote rules leave marker comments in the token stream, carrying
+// the hex encoded label, which the footnotes pass replaces.
+const (
+	footnoteMarker    = "<!--mdview-footnote "
+	footnoteEndMarker = "<!--/mdview-footnote-->\n"
+	footnoteRefMarker = "<!--mdview-fnref "
+)
+
+func init() {
+	markdown.RegisterBlockRule(650, ruleFootnoteDef, []int{1100, 700})
+	markdown.RegisterInlineRule(650, ruleFootnoteRef)
+}
+
+// footnoteLabel parses the "[^label]" at the start of src, returning the
+// label and its length, or -1 if there is none.
+func footnoteLabel(src string) (string, int) {
+	if !strings.HasPrefix(src, "[^") {
+		return "", -1
+	}
+	for i := 2; i < len(src); i++ {
+		switch src[i] {
+		case ']':
+			if i == 2 {
+				return "", -1
+			}
+			return src[2:i], i + 1
+		case ' ', '\t', '\n', '[':
+			return "", -1
+		}
+	}
+	return "", -1
+}
+
+// ruleFootnoteDef parses footnote definitions,
+//
+//	[^label]: Text of the footnote,
+//	    which continues on lines indented by four spaces.
+//
+// as marker comments around the definition's blocks.
+func ruleFootnoteDef(s *markdown.StateBlock, startLine, endLine int, silent bool) bool {
+	if s.SCount[startLine]-s.BlkIndent >= 4 {
+		return false
+	}
+	start, max := s.BMarks[startLine]+s.TShift[startLine], s.EMarks[startLine]
+	label, n := footnoteLabel(s.Src[start:max])
+	if n < 0 || start+n >= max || s.Src[start+n] != ':' {
+		return false
+	}
+	if silent {
+		return true
+	}
+
+	s.PushToken(&markdown.HTMLBlock{
+		Content: footnoteMarker + hex.EncodeToString([]byte(label)) + "-->\n",
+		Map:     [2]int{startLine, startLine + 1},
+	})
+
+	// Parse the rest of the first line as if it were indented like the
+	// lines that follow it.
+	oldBMark, oldTShift, oldSCount := s.BMarks[startLine], s.TShift[startLine], s.SCount[startLine]
+	pos := start + n + 1
+	afterColon := pos
+	initial := s.SCount[startLine] + pos - start
+	offset := initial
+spaces:
+	for ; pos < max; pos++ {
+		switch s.Src[pos] {
+		case ' ':
+			offset++
+		case '\t':
+			offset += 4 - offset%4
+		default:
+			break spaces
+		}
+	}
+	s.BMarks[startLine] = afterColon
+	s.TShift[startLine] = pos - afterColon
+	s.SCount[startLine] = offset - initial
+	s.BlkIndent += 4
+	if s.SCount[startLine] < s.BlkIndent {
+		s.SCount[startLine] += s.BlkIndent
+	}
+	s.Md.Block.Tokenize(s, startLine, endLine)
+	s.BlkIndent -= 4
+	s.BMarks[startLine], s.TShift[startLine], s.SCount[startLine] = oldBMark, oldTShift, oldSCount
+
+	s.PushToken(&markdown.HTMLBlock{Content: footnoteEndMarker})
+	return true
+}
+
+// ruleFootnoteRef parses footnote references such as [^1] as marker
+// comments.
+func ruleFootnoteRef(s *markdown.StateInline, silent bool) bool {
+	label, n := footnoteLabel(s.Src[s.Pos:s.PosMax])
+	if n < 0 {
+		return false
+	}
+	if !silent {
+		s.PushToken(&markdown.HTMLInline{Content: footnoteRefMarker + hex.EncodeToString([]byte(label)) + "-->"})
+	}
+	s.Pos += n
+	return true
+}
+
+// markerLabel decodes the label carried by a marker comment.
+func markerLabel(content, marker string) string {
+	enc := strings.TrimSuffix(strings.TrimSpace(content[len(marker):]), "-->")
+	label, _ := hex.DecodeString(enc)
+	return string(label)
+}
+
+// footnotes numbers footnotes in the order they are first referenced and
+// moves their definitions into a list at the end of the document. Ids are
+// derived from the labels, so links to a footnote survive edits elsewhere.
+// References without a definition are left as they were written, and
+// definitions that are never referenced are dropped.
+func (d *document) footnotes() bool {
+	defs := make(map[string][]markdown.Token)
+	for i := 0; i < len(d.tokens); i++ {
+		tok, ok := d.tokens[i].(*markdown.HTMLBlock)
+		if !ok || !strings.HasPrefix(tok.Content, footnoteMarker) {
+			continue
+		}
+		label := markerLabel(tok.Content, footnoteMarker)
+		depth, j := 0, i
+		for ; j < len(d.tokens); j++ {
+			if b, ok := d.tokens[j].(*markdown.HTMLBlock); ok {
+				if strings.HasPrefix(b.Content, footnoteMarker) {
+					depth++
+				} else if b.Content == footnoteEndMarker {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+		}
+		if j == len(d.tokens) {
+			j--
+		}
+		if _, dup := defs[label]; !dup {
+			defs[label] = append([]markdown.Token(nil), d.tokens[i+1:j]...)
+		}
+		d.tokens = append(d.tokens[:i], d.tokens[j+1:]...)
+		i--
+	}
+
+	var order []string
+	number := make(map[string]int)
+	refs := make(map[string]int)
+	link := func(tokens []markdown.Token) {
+		for _, tok := range tokens {
+			inline, ok := tok.(*markdown.Inline)
+			if !ok {
+				continue
+			}
+			for _, child := range inline.Children {
+				ref, ok := child.(*markdown.HTMLInline)
+				if !ok || !strings.HasPrefix(ref.Content, footnoteRefMarker) {
+					continue
+				}
+				label := markerLabel(ref.Content, footnoteRefMarker)
+				if _, ok := defs[label]; !ok {
+					ref.Content = html.EscapeString("[^" + label + "]")
+					continue
+				}
+				if number[label] == 0 {
+					order = append(order, label)
+					number[label] = len(order)
+				}
+				refs[label]++
+				ref.Content = fmt.Sprintf(`<sup class="footnote-ref"><a href="#fn-%s" id="%s">%d</a></sup>`,
+					footnoteID(label), footnoteRefID(label, refs[label]), number[label])
+			}
+		}
+	}
+	link(d.tokens)
+	// Footnotes may refer to other footnotes.
+	for i := 0; i < len(order); i++ {
+		link(defs[order[i]])
+	}
+	if len(order) == 0 {
+		return false
+	}
+
+	d.tokens = append(d.tokens,
+		&markdown.HTMLBlock{Content: `<section class="footnotes">` + "\n"},
+		&markdown.Hr{},
+		&markdown.HTMLBlock{Content: "<ol>\n"})
+	for _, label := range order {
+		var back strings.Builder
+		for k := 1; k <= refs[label]; k++ {
+			fmt.Fprintf(&back, ` <a href="#%s" class="footnote-backref" aria-label="Back to reference">↩`, footnoteRefID(label, k))
+			if k > 1 {
+				fmt.Fprintf(&back, "<sup>%d</sup>", k)
+			}
+			back.WriteString("</a>")
+		}
+
+		body := defs[label]
+		d.tokens = append(d.tokens, &markdown.HTMLBlock{Content: `<li id="fn-` + footnoteID(label) + `">` + "\n"})
+		if n := len(body); n >= 2 {
+			if _, ok := body[n-1].(*markdown.ParagraphClose); ok {
+				if inline, ok := body[n-2].(*markdown.Inline); ok {
+					inline.Children = append(inline.Children, &markdown.HTMLInline{Content: back.String()})
+					back.Reset()
+				}
+			}
+		}
+		d.tokens = append(d.tokens, body...)
+		if back.Len() > 0 {
+			d.tokens = append(d.tokens, &markdown.HTMLBlock{Content: "<p>" + strings.TrimSpace(back.String()) + "</p>\n"})
+		}
+		d.tokens = append(d.tokens, &markdown.HTMLBlock{Content: "</li>\n"})
+	}
+	d.tokens = append(d.tokens, &markdown.HTMLBlock{Content: "</ol>\n</section>\n"})
+	return true
+}
+
+// footnoteID turns a footnote label into the id of its definition.
+func footnoteID(label string) string {
+	if id := slugify(label); id != "" {
+		return id
+	}
+	return hex.EncodeToString([]byte(label))
+}
+
+// footnoteRefID is the id of the n-th reference to a footnote.
+func footnoteRefID(label string, n int) string {
+	id := "fnref-" + footnoteID(label)
+	if n > 1 {
+		id += "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+// footnotePopupScript shows a footnote's text next to its reference while the
+// pointer rests on it.
+const footnotePopupScript = `<script>(function(){
+var pop=null;
+function ref(e){return e.target.closest?e.target.closest(".footnote-ref a"):null}
+document.addEventListener("mouseover",function(e){
+var a=ref(e);if(!a||pop)return;
+var fn=document.getElementById(decodeURIComponent(a.getAttribute("href").substring(1)));if(!fn)return;
+pop=document.createElement("div");pop.className="footnote-popup";pop.innerHTML=fn.innerHTML;
+var back=pop.querySelectorAll(".footnote-backref");for(var i=0;i<back.length;i++)back[i].parentNode.removeChild(back[i]);
+pop.style.position="absolute";(document.querySelector(".markdown-body")||document.body).appendChild(pop);
+var r=a.getBoundingClientRect(),left=Math.min(r.left,document.documentElement.clientWidth-pop.offsetWidth-8);
+pop.style.left=Math.max(8,left)+window.pageXOffset+"px";pop.style.top=r.bottom+window.pageYOffset+4+"px"});
+document.addEventListener("mouseout",function(e){if(pop&&ref(e)){pop.parentNode.removeChild(pop);pop=null}});
+})();</script>`
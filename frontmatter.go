@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// metadata holds a document's front matter: strings, or lists of strings,
+// by key.
+type metadata map[string]interface{}
+
+// str returns the string set for key, or "".
+func (m metadata) str(key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// list returns the strings set for key, which may be a single string or a
+// list.
+func (m metadata) list(key string) []string {
+	switch v := m[key].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []string:
+		return v
+	}
+	return nil
+}
+
+// frontMatter splits the YAML front matter, between a "---" line at the very
+// start of src and the next "---" or "..." line, from the document. The
+// front matter's lines are blanked rather than removed, so that the lines
+// of the document keep their numbers.
+//
+// Only the simple YAML found in front matter is understood: top level keys
+// with plain or quoted strings, flow lists such as [a, b], block lists of
+// "- item" lines and | or > block scalars. Nested mappings are skipped.
+func frontMatter(src []byte) (metadata, []byte) {
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	if len(lines) == 0 || strings.TrimRight(string(lines[0]), " \t\r\n") != "---" {
+		return nil, src
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if l := strings.TrimRight(string(lines[i]), " \t\r\n"); l == "---" || l == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, src
+	}
+
+	meta := make(metadata)
+	var key string
+	var block []string
+	var fold bool
+	flush := func() {
+		if block == nil {
+			return
+		}
+		sep := "\n"
+		if fold {
+			sep = " "
+		}
+		meta[key] = strings.TrimSpace(strings.Join(block, sep))
+		block = nil
+	}
+	for _, line := range lines[1:end] {
+		l := strings.TrimRight(string(line), " \t\r\n")
+		indented := l != "" && (l[0] == ' ' || l[0] == '\t')
+		if block != nil && (indented || l == "") {
+			block = append(block, strings.TrimSpace(l))
+			continue
+		}
+		flush()
+		switch t := strings.TrimSpace(l); {
+		case t == "" || t[0] == '#':
+		case indented && strings.HasPrefix(t, "- "):
+			if list, ok := meta[key].([]string); ok {
+				meta[key] = append(list, yamlScalar(t[2:]))
+			}
+		case indented:
+			// Part of a nested mapping.
+		default:
+			colon := strings.Index(l, ":")
+			if colon < 0 {
+				continue
+			}
+			key = strings.TrimSpace(l[:colon])
+			value := strings.TrimSpace(l[colon+1:])
+			switch {
+			case value == "":
+				meta[key] = []string{}
+			case value[0] == '|' || value[0] == '>':
+				block, fold = []string{}, value[0] == '>'
+			case value[0] == '[' && strings.HasSuffix(value, "]"):
+				var list []string
+				for _, item := range strings.Split(value[1:len(value)-1], ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						list = append(list, yamlScalar(item))
+					}
+				}
+				meta[key] = list
+			default:
+				meta[key] = yamlScalar(value)
+			}
+		}
+	}
+	flush()
+
+	var b bytes.Buffer
+	for i := 0; i <= end; i++ {
+		b.WriteByte('\n')
+	}
+	for _, line := range lines[end+1:] {
+		b.Write(line)
+	}
+	return meta, b.Bytes()
+}
+
+// yamlScalar returns the string a plain or quoted YAML scalar stands for.
+func yamlScalar(v string) string {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		if s, err := strconv.Unquote(v); err == nil {
+			return s
+		}
+		return strings.Trim(v, `"`)
+	case strings.HasPrefix(v, "'"):
+		return strings.Replace(strings.Trim(v, "'"), "''", "'", -1)
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v
+}
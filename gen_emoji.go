@@ -0,0 +1,66 @@
+//go:build ignore
+// +build ignore
+
+// gen_emoji downloads GitHub's emoji database from the pinned gemoji
+// release and writes its shortcodes to emoji_table.go.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+)
+
+const gemojiVersion = "v4.1.0"
+
+func main() {
+	url := "https://raw.githubusercontent.com/github/gemoji/" + gemojiVersion + "/db/emoji.json"
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("%s: %s", url, resp.Status)
+	}
+	var db []struct {
+		Emoji   string   `json:"emoji"`
+		Aliases []string `json:"aliases"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&db); err != nil {
+		log.Fatal(err)
+	}
+
+	emojis := make(map[string]string)
+	var names []string
+	for _, e := range db {
+		for _, alias := range e.Aliases {
+			if _, ok := emojis[alias]; !ok {
+				names = append(names, alias)
+			}
+			emojis[alias] = e.Emoji
+		}
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gen_emoji.go; DO NOT EDIT.\n\npackage main\n\n")
+	fmt.Fprintf(&b, "// emojis maps GitHub's emoji shortcodes, without the colons, to the\n// emoji they stand for.\n")
+	fmt.Fprintf(&b, "var emojis = map[string]string{\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\t%q: %q,\n", name, emojis[name])
+	}
+	fmt.Fprintf(&b, "}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = ioutil.WriteFile("emoji_table.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
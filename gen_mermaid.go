@@ -0,0 +1,56 @@
+//go:build ignore
+// +build ignore
+
+// gen_mermaid downloads the pinned mermaid release and writes it to
+// mermaid_runtime.go, to be compiled into mdview.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+)
+
+const version = "10.9.1"
+
+// sum is the SHA-256 of mermaid.min.js at version, which the download must
+// match. A mismatch reports the download's, to be checked and pinned along
+// with a new version.
+const sum = ""
+
+func main() {
+	url := "https://cdn.jsdelivr.net/npm/mermaid@" + version + "/dist/mermaid.min.js"
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("%s: %s", url, resp.Status)
+	}
+	js, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sum == "" {
+		log.Fatalf("%s: no sha256 pinned; check that the download's, %x, is the release's and set sum to it", url, sha256.Sum256(js))
+	}
+	if got := sha256.Sum256(js); hex.EncodeToString(got[:]) != sum {
+		log.Fatalf("%s: sha256 %x, want %q", url, got, sum)
+	}
+
+	f, err := os.Create("mermaid_runtime.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(f, "// Code generated by gen_mermaid.go; DO NOT EDIT.\n\npackage main\n\n")
+	fmt.Fprintf(f, "// mermaidVersion and mermaidRuntime hold the bundled mermaid library.\n")
+	fmt.Fprintf(f, "const (\n\tmermaidVersion = %q\n\tmermaidRuntime = %q\n)\n", version, js)
+	if err = f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// location is a place in a document to open the preview at: a heading,
+// given by its slug or text, or a line of the markdown source.
+type location struct {
+	heading string
+	line    int
+}
+
+var lineSuffix = regexp.MustCompile(`^(.+):(\d+)$`)
+
+// splitLocation separates a command line argument such as README.md#usage
+// or README.md:120 into the file name and the location within it. Names of
+// files that exist are taken as they are.
+func splitLocation(arg string) (string, location) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, location{}
+	}
+	if i := strings.LastIndex(arg, "#"); i > 0 {
+		return arg[:i], location{heading: slugify(arg[i+1:])}
+	}
+	if m := lineSuffix.FindStringSubmatch(arg); m != nil {
+		line, _ := strconv.Atoi(m[2])
+		return m[1], location{line: line}
+	}
+	return arg, location{}
+}
+
+// fragment returns the URL fragment, without '#', that the page scripts
+// understand for l: a heading id or line=<n>.
+func (l location) fragment() string {
+	if l.line > 0 {
+		return "line=" + strconv.Itoa(l.line)
+	}
+	return l.heading
+}
+
+// gotoFunc defines mdviewGoto, which scrolls to a fragment as returned by
+// location.fragment.
+const gotoFunc = `<script>function mdviewGoto(f){
+var m=/^line=(\d+)$/.exec(f),el=null;
+if(m){var els=document.querySelectorAll("[data-source-line]");
+for(var i=0;i<els.length;i++){if(parseInt(els[i].getAttribute("data-source-line"),10)<=+m[1])el=els[i];else break}}
+else el=document.getElementById(f);
+if(el)el.scrollIntoView();return el!==null}</script>`
+
+// gotoScript returns the script that scrolls a static page to l once it
+// has loaded; file:// URLs opened by the browser launcher lose fragments.
+func gotoScript(l location) string {
+	return gotoFunc + fmt.Sprintf(`<script>window.addEventListener("load",function(){mdviewGoto(%q)})</script>`, l.fragment())
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// syntax describes just enough of a language to colour its source with the
+// GitHub stylesheet's pl-* classes.
+type syntax struct {
+	lineComments  []string
+	blockComments [][2]string
+	quotes        string
+	keywords      []string
+}
+
+var (
+	cSyntax = syntax{
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'",
+	}
+	shellSyntax = syntax{
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keywords: []string{"if", "then", "else", "elif", "fi", "for", "while", "until", "do", "done",
+			"case", "esac", "in", "function", "return", "local", "export", "exit"},
+	}
+)
+
+var syntaxes = map[string]syntax{
+	"go": {
+		lineComments:  cSyntax.lineComments,
+		blockComments: cSyntax.blockComments,
+		quotes:        "\"'`",
+		keywords: []string{"break", "case", "chan", "const", "continue", "default", "defer", "else",
+			"fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package",
+			"range", "return", "select", "struct", "switch", "type", "var", "nil", "true", "false"},
+	},
+	"c": {
+		lineComments:  cSyntax.lineComments,
+		blockComments: cSyntax.blockComments,
+		quotes:        cSyntax.quotes,
+		keywords: []string{"auto", "break", "case", "char", "const", "continue", "default", "do",
+			"double", "else", "enum", "extern", "float", "for", "goto", "if", "int", "long", "return",
+			"short", "signed", "sizeof", "static", "struct", "switch", "typedef", "union", "unsigned",
+			"void", "volatile", "while", "class", "namespace", "public", "private", "protected",
+			"template", "new", "delete", "true", "false", "NULL", "nullptr"},
+	},
+	"java": {
+		lineComments:  cSyntax.lineComments,
+		blockComments: cSyntax.blockComments,
+		quotes:        cSyntax.quotes,
+		keywords: []string{"abstract", "boolean", "break", "case", "catch", "class", "continue",
+			"default", "do", "else", "extends", "final", "finally", "for", "if", "implements",
+			"import", "instanceof", "int", "interface", "new", "package", "private", "protected",
+			"public", "return", "static", "super", "switch", "this", "throw", "throws", "try",
+			"void", "while", "true", "false", "null"},
+	},
+	"js": {
+		lineComments:  cSyntax.lineComments,
+		blockComments: cSyntax.blockComments,
+		quotes:        "\"'`",
+		keywords: []string{"async", "await", "break", "case", "catch", "class", "const", "continue",
+			"default", "delete", "do", "else", "export", "extends", "finally", "for", "function",
+			"if", "import", "in", "instanceof", "let", "new", "return", "switch", "this", "throw",
+			"try", "typeof", "var", "void", "while", "yield", "true", "false", "null", "undefined",
+			"interface", "type", "enum"},
+	},
+	"python": {
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keywords: []string{"and", "as", "assert", "async", "await", "break", "class", "continue",
+			"def", "del", "elif", "else", "except", "finally", "for", "from", "global", "if",
+			"import", "in", "is", "lambda", "nonlocal", "not", "or", "pass", "raise", "return",
+			"try", "while", "with", "yield", "True", "False", "None"},
+	},
+	"ruby": {
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keywords: []string{"begin", "class", "def", "do", "else", "elsif", "end", "ensure", "if",
+			"module", "nil", "require", "rescue", "return", "self", "then", "unless", "until",
+			"when", "while", "yield", "true", "false"},
+	},
+	"rust": {
+		lineComments:  cSyntax.lineComments,
+		blockComments: cSyntax.blockComments,
+		quotes:        "\"",
+		keywords: []string{"as", "break", "const", "continue", "crate", "else", "enum", "extern",
+			"fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move", "mut", "pub",
+			"ref", "return", "self", "Self", "static", "struct", "trait", "type", "unsafe", "use",
+			"where", "while", "true", "false"},
+	},
+	"sh":   shellSyntax,
+	"make": {lineComments: []string{"#"}, quotes: "\"'"},
+	"yaml": {lineComments: []string{"#"}, quotes: "\"'", keywords: []string{"true", "false", "null"}},
+	"toml": {lineComments: []string{"#"}, quotes: "\"'", keywords: []string{"true", "false"}},
+	"json": {quotes: "\"", keywords: []string{"true", "false", "null"}},
+	"css":  {blockComments: cSyntax.blockComments, quotes: "\"'"},
+	"html": {blockComments: [][2]string{{"<!--", "-->"}}, quotes: "\""},
+	"sql": {
+		lineComments:  []string{"--"},
+		blockComments: cSyntax.blockComments,
+		quotes:        "'\"",
+		keywords: []string{"select", "from", "where", "insert", "into", "values", "update", "set",
+			"delete", "create", "table", "drop", "alter", "join", "left", "right", "inner", "outer",
+			"on", "and", "or", "not", "null", "group", "by", "order", "having", "limit", "as",
+			"SELECT", "FROM", "WHERE", "INSERT", "INTO", "VALUES", "UPDATE", "SET", "DELETE",
+			"CREATE", "TABLE", "DROP", "ALTER", "JOIN", "LEFT", "RIGHT", "INNER", "OUTER", "ON",
+			"AND", "OR", "NOT", "NULL", "GROUP", "BY", "ORDER", "HAVING", "LIMIT", "AS"},
+	},
+}
+
+var extensionLanguages = map[string]string{
+	".go": "go", ".c": "c", ".h": "c", ".cc": "c", ".cpp": "c", ".hpp": "c", ".cs": "c",
+	".java": "java", ".kt": "java", ".scala": "java", ".js": "js", ".mjs": "js", ".ts": "js",
+	".jsx": "js", ".tsx": "js", ".py": "python", ".rb": "ruby", ".rs": "rust", ".sh": "sh",
+	".bash": "sh", ".zsh": "sh", ".mk": "make", ".yml": "yaml", ".yaml": "yaml", ".toml": "toml",
+	".json": "json", ".css": "css", ".html": "html", ".htm": "html", ".xml": "html",
+	".svg": "html", ".sql": "sql",
+}
+
+// languageOf guesses the language of a source file from its name.
+func languageOf(name string) string {
+	switch base := filepath.Base(name); base {
+	case "Makefile", "makefile", "GNUmakefile":
+		return "make"
+	case "Dockerfile", ".bashrc", ".profile", ".zshrc":
+		return "sh"
+	}
+	return extensionLanguages[strings.ToLower(filepath.Ext(name))]
+}
+
+// sourceView renders src as a GitHub-style blob: highlighted code next to
+// a column of line numbers that can be linked to as #L<n>.
+func sourceView(src, lang string) string {
+	lines := strings.Count(src, "\n")
+	if !strings.HasSuffix(src, "\n") {
+		lines++
+	}
+	var nums strings.Builder
+	for i := 1; i <= lines; i++ {
+		fmt.Fprintf(&nums, "<a id=\"L%d\" href=\"#L%d\">%d</a>\n", i, i, i)
+	}
+	return "<table class=\"mdview-source\"><tr><td class=\"mdview-lines\"><pre>" + nums.String() +
+		"</pre></td><td><pre><code>" + highlight(src, lang) + "</code></pre></td></tr></table>\n"
+}
+
+// highlight escapes src for HTML, wrapping comments, strings, numbers and
+// keywords in spans when lang is known.
+func highlight(src, lang string) string {
+	syn, ok := syntaxes[lang]
+	if !ok {
+		return html.EscapeString(src)
+	}
+	keywords := make(map[string]bool, len(syn.keywords))
+	for _, k := range syn.keywords {
+		keywords[k] = true
+	}
+
+	var b strings.Builder
+	span := func(class, text string) {
+		fmt.Fprintf(&b, "<span class=\"%s\">%s</span>", class, html.EscapeString(text))
+	}
+
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		if end := commentEnd(rest, syn); end > 0 {
+			span("pl-c", rest[:end])
+			i += end
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case strings.ContainsRune(syn.quotes, c):
+			end := stringEnd(rest, c)
+			span("pl-s", rest[:end])
+			i += end
+		case unicode.IsDigit(c):
+			end := wordEnd(rest, true)
+			span("pl-c1", rest[:end])
+			i += end
+		case unicode.IsLetter(c) || c == '_':
+			end := wordEnd(rest, false)
+			if keywords[rest[:end]] {
+				span("pl-k", rest[:end])
+			} else {
+				b.WriteString(html.EscapeString(rest[:end]))
+			}
+			i += end
+		default:
+			b.WriteString(html.EscapeString(rest[:size]))
+			i += size
+		}
+	}
+	return b.String()
+}
+
+// commentEnd returns the length of the comment at the start of s, or 0.
+func commentEnd(s string, syn syntax) int {
+	for _, lc := range syn.lineComments {
+		if strings.HasPrefix(s, lc) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	for _, bc := range syn.blockComments {
+		if strings.HasPrefix(s, bc[0]) {
+			if end := strings.Index(s[len(bc[0]):], bc[1]); end >= 0 {
+				return len(bc[0]) + end + len(bc[1])
+			}
+			return len(s)
+		}
+	}
+	return 0
+}
+
+// stringEnd returns the length of the string literal opened by quote at the
+// start of s. Only backtick strings may span lines.
+func stringEnd(s string, quote rune) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote != '`':
+			i++
+		case rune(s[i]) == quote:
+			return i + 1
+		case s[i] == '\n' && quote != '`':
+			return i
+		}
+	}
+	return len(s)
+}
+
+// wordEnd returns the length of the identifier or number at the start of s.
+func wordEnd(s string, number bool) int {
+	for i, c := range s {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && !(number && c == '.') {
+			return i
+		}
+	}
+	return len(s)
+}
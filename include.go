@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func init() {
+	markdown.RegisterBlockRule(370, ruleInclude, []int{1100, 700, 400, 600})
+}
+
+const includeMarker = "<!--mdview-include "
+
+// ruleInclude parses include directives such as
+//
+//	!include ../shared/license.md {shift=1}
+//
+// into a marker that document.includes replaces with the file's contents.
+// The path may be quoted; the optional attribute block shifts the included
+// headings by that many levels.
+func ruleInclude(s *markdown.StateBlock, startLine, endLine int, silent bool) bool {
+	if s.SCount[startLine]-s.BlkIndent >= 4 {
+		return false
+	}
+	line := s.Src[s.BMarks[startLine]+s.TShift[startLine] : s.EMarks[startLine]]
+	if !strings.HasPrefix(line, "!include ") {
+		return false
+	}
+	path, shift, ok := parseInclude(strings.TrimSpace(line[len("!include "):]))
+	if !ok {
+		return false
+	}
+	if silent {
+		return true
+	}
+	s.PushToken(&markdown.HTMLBlock{
+		Content: includeMarker + hex.EncodeToString([]byte(path)) + " " + strconv.Itoa(shift) + "-->\n",
+		Map:     [2]int{startLine, startLine + 1},
+	})
+	s.Line = startLine + 1
+	return true
+}
+
+// parseInclude parses the path and attribute block of an include
+// directive.
+func parseInclude(s string) (string, int, bool) {
+	var path string
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		v, n := attributeValue(s)
+		if n < 0 {
+			return "", 0, false
+		}
+		path, s = v, s[n:]
+	} else {
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		path, s = s[:end], s[end:]
+	}
+	if path == "" {
+		return "", 0, false
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return path, 0, true
+	}
+	attrs, end := parseAttributes(s)
+	if end != len(s) {
+		return "", 0, false
+	}
+	shift := 0
+	for _, a := range attrs {
+		if a.name != "shift" {
+			continue
+		}
+		n, err := strconv.Atoi(a.value)
+		if err != nil {
+			return "", 0, false
+		}
+		shift = n
+	}
+	return path, shift, true
+}
+
+// includeStep is an include directive on the way to a file.
+type includeStep struct {
+	name string
+	line int
+}
+
+// includes replaces include directives with the contents of the files they
+// name, found relative to the file doing the including, which for the
+// document itself is name. Included files see the document's vars and,
+// unless confine is empty, must be inside that directory once symlinks are
+// followed. It returns the files included, for watching them, and any
+// errors, which name the chain of includes leading to them and are also
+// shown in place of the directive.
+func (d *document) includes(md *markdown.Markdown, name, confine string, vars map[string]string) ([]string, []string) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = name
+	}
+	in := &includer{md: md, root: abs, confine: confine, vars: vars, included: make(map[markdown.Token]bool)}
+	d.tokens = in.expand(d.tokens, abs, nil)
+	d.included = in.included
+	return in.deps, in.errs
+}
+
+type includer struct {
+	md       *markdown.Markdown
+	root     string // the document doing the including
+	confine  string // the directory included files must be in, if any
+	vars     map[string]string
+	deps     []string
+	errs     []string
+	included map[markdown.Token]bool
+}
+
+// expand splices the files toks, read from name, include into toks.
+func (in *includer) expand(toks []markdown.Token, name string, chain []includeStep) []markdown.Token {
+	var out []markdown.Token
+	for _, tok := range toks {
+		block, ok := tok.(*markdown.HTMLBlock)
+		if !ok || !strings.HasPrefix(block.Content, includeMarker) {
+			out = append(out, tok)
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(block.Content[len(includeMarker):]), "-->"))
+		if len(fields) != 2 {
+			continue
+		}
+		path, _ := hex.DecodeString(fields[0])
+		shift, _ := strconv.Atoi(fields[1])
+
+		steps := append(chain[:len(chain):len(chain)], includeStep{name, block.Map[0] + 1})
+		included, err := in.include(string(path), shift, steps)
+		if err != nil {
+			msg := err.Error()
+			in.errs = append(in.errs, msg)
+			out = append(out, &markdown.HTMLBlock{
+				Content: `<pre class="mdview-filter-error">` + html.EscapeString(msg) + "</pre>\n",
+				Map:     block.Map,
+				Lvl:     block.Lvl,
+			})
+			continue
+		}
+		out = append(out, included...)
+	}
+	return out
+}
+
+// include parses the file path, as included by the last of steps, along
+// with the files it includes in turn.
+func (in *includer) include(path string, shift int, steps []includeStep) ([]markdown.Token, error) {
+	from := steps[len(steps)-1].name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), filepath.FromSlash(path))
+	}
+	for _, step := range steps {
+		if step.name == path {
+			names := make([]string, 0, len(steps)+1)
+			for _, s := range steps {
+				names = append(names, in.display(s.name))
+			}
+			return nil, fmt.Errorf("include cycle: %s → %s", strings.Join(names, " → "), in.display(path))
+		}
+	}
+	src, err := ioutil.ReadFile(path)
+	if err == nil && in.confine != "" {
+		if real, rerr := filepath.EvalSymlinks(path); rerr != nil || !within(in.confine, real) {
+			err = errOutsideRoot
+		}
+	}
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		return nil, fmt.Errorf("%s: cannot include %s: %v", in.trace(steps), in.display(path), err)
+	}
+	in.deps = append(in.deps, path)
+
+	_, src = frontMatter(src)
+	src, problems := preprocess(src, in.vars)
+	for _, p := range problems {
+		in.errs = append(in.errs, in.display(path)+":"+p)
+	}
+	toks := in.expand(in.md.Parse(src), path, steps)
+	deps, errs := snippets(toks, path, in.confine)
+	in.deps = append(in.deps, deps...)
+	in.errs = append(in.errs, errs...)
+	for _, tok := range toks {
+		switch tok := tok.(type) {
+		case *markdown.HeadingOpen:
+			tok.HLevel = shiftLevel(tok.HLevel, shift)
+		case *markdown.HeadingClose:
+			tok.HLevel = shiftLevel(tok.HLevel, shift)
+		}
+		in.included[tok] = true
+	}
+	return toks, nil
+}
+
+// trace describes the chain of includes steps, as in
+// "README.md:12 → docs/setup.md:3".
+func (in *includer) trace(steps []includeStep) string {
+	parts := make([]string, len(steps))
+	for i, s := range steps {
+		parts[i] = in.display(s.name) + ":" + strconv.Itoa(s.line)
+	}
+	return strings.Join(parts, " → ")
+}
+
+// display shortens name relative to the directory of the document.
+func (in *includer) display(name string) string {
+	if rel, err := filepath.Rel(filepath.Dir(in.root), name); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return name
+}
+
+func shiftLevel(level, shift int) int {
+	level += shift
+	switch {
+	case level < 1:
+		return 1
+	case level > 6:
+		return 6
+	}
+	return level
+}
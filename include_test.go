@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func TestIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdview-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"main.md":       "# Main\n\n!include parts/part.md {shift=1}\n\n!include missing.md\n",
+		"parts/part.md": "# Part\n\n!include loop.md\n",
+		"parts/loop.md": "---\ntitle: Loop\n---\nlooping\n\n!include \"../parts/part.md\"\n",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	md := newRenderer(false, false, false).md
+	top := filepath.Join(dir, "main.md")
+	doc := &document{tokens: md.Parse([]byte(files["main.md"]))}
+	deps, errs := doc.includes(md, top, "", nil)
+
+	wantDeps := []string{filepath.Join(dir, "parts", "part.md"), filepath.Join(dir, "parts", "loop.md")}
+	if !reflect.DeepEqual(deps, wantDeps) {
+		t.Errorf("deps = %q, want %q", deps, wantDeps)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("errors = %q, want a cycle and a missing file", errs)
+	}
+	if want := "include cycle: main.md → parts/part.md → parts/loop.md → parts/part.md"; errs[0] != want {
+		t.Errorf("cycle error = %q, want %q", errs[0], want)
+	}
+	if want := "main.md:5: cannot include missing.md: "; !strings.HasPrefix(errs[1], want) {
+		t.Errorf("missing file error = %q, want it to start with %q", errs[1], want)
+	}
+
+	var headings []int
+	var text []string
+	included := 0
+	for _, tok := range doc.tokens {
+		switch tok := tok.(type) {
+		case *markdown.HeadingOpen:
+			headings = append(headings, tok.HLevel)
+		case *markdown.Inline:
+			text = append(text, tok.Content)
+		case *markdown.HTMLBlock:
+			if strings.Contains(tok.Content, "mdview-filter-error") {
+				text = append(text, "error")
+			}
+		}
+		if doc.included[tok] {
+			included++
+		}
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(headings, want) {
+		t.Errorf("heading levels = %v, want %v", headings, want)
+	}
+	if want := []string{"Main", "Part", "looping", "error", "error"}; !reflect.DeepEqual(text, want) {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+	if included == 0 || doc.included[doc.tokens[0]] {
+		t.Errorf("included tokens are not told apart from the document's own")
+	}
+}
+
+func TestIncludesConfined(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdview-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"secret.md": "secret\n", "root/part.md": "part\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.md"), filepath.Join(root, "link.md")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	src := "!include part.md\n\n!include ../secret.md\n\n!include link.md\n\n!include " + filepath.ToSlash(filepath.Join(dir, "secret.md")) + "\n"
+	md := newRenderer(false, false, false).md
+	for _, confine := range []string{"", root} {
+		doc := &document{tokens: md.Parse([]byte(src))}
+		_, errs := doc.includes(md, filepath.Join(root, "main.md"), confine, nil)
+		var text []string
+		for _, tok := range doc.tokens {
+			if inline, ok := tok.(*markdown.Inline); ok {
+				text = append(text, inline.Content)
+			}
+		}
+		want := []string{"part", "secret", "secret", "secret"}
+		if confine != "" {
+			want = want[:1]
+			if len(errs) != 3 {
+				t.Errorf("errors including files outside %s = %q, want 3", confine, errs)
+			}
+		}
+		if !reflect.DeepEqual(text, want) {
+			t.Errorf("included text confined to %q = %q, want %q", confine, text, want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/browser"
+)
+
+// browserCommand is the -browser option: a preset name or a command line in
+// which %s stands for the page to open. When empty, $BROWSER is used, and
+// failing that the system default browser.
+var browserCommand string
+
+// noOpen is the -no-open option: print what would be opened instead.
+var noOpen bool
+
+// browserPresets are the launch commands that can be chosen by name.
+var browserPresets = map[string]string{
+	"chromium":       "chromium --new-window %s",
+	"chromium-app":   "chromium --app=%s",
+	"chromium-kiosk": "chromium --kiosk %s",
+	"chrome":         "google-chrome --new-window %s",
+	"chrome-app":     "google-chrome --app=%s",
+	"chrome-kiosk":   "google-chrome --kiosk %s",
+	"firefox":        "firefox --new-window %s",
+	"firefox-kiosk":  "firefox --kiosk %s",
+}
+
+// openURL shows url in the configured browser.
+func openURL(url string) error {
+	if noOpen {
+		_, err := fmt.Println(url)
+		return err
+	}
+	if browserCommand != "" {
+		return launch(browserCommand, url)
+	}
+	commands := os.Getenv("BROWSER")
+	if commands == "" {
+		return browser.OpenURL(url)
+	}
+
+	// Like other tools, accept a colon separated list in $BROWSER and use
+	// the first one that can be started.
+	var err error
+	for _, c := range strings.Split(commands, string(os.PathListSeparator)) {
+		if err = launch(c, url); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// openPath shows the file at name in the configured browser.
+func openPath(name string) error {
+	if noOpen {
+		_, err := fmt.Println(name)
+		return err
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasPrefix(abs, "/") {
+		abs = "/" + abs // C:/... on Windows
+	}
+	return openURL((&url.URL{Scheme: "file", Path: abs}).String())
+}
+
+// terminalBrowsers are browsers that run in the terminal, which they need
+// to keep.
+var terminalBrowsers = map[string]bool{
+	"w3m": true, "lynx": true, "links": true, "links2": true, "elinks": true,
+	"browsh": true, "carbonyl": true,
+}
+
+// launch starts command, a preset name or command line, for url.
+func launch(command, url string) error {
+	if preset, ok := browserPresets[command]; ok {
+		command = preset
+	}
+	args := splitCommand(command)
+	if len(args) == 0 {
+		return errors.New("empty browser command")
+	}
+	substituted := false
+	for i, a := range args {
+		var ok bool
+		args[i], ok = expandBrowserArg(a, url)
+		substituted = substituted || ok
+	}
+	if !substituted {
+		args = append(args, url)
+	}
+
+	// Terminal browsers take over the terminal until they quit. Others are
+	// left running on their own rather than waited for, as some only exit
+	// when their window is closed.
+	cmd := exec.Command(args[0], args[1:]...)
+	if terminalBrowsers[strings.TrimSuffix(filepath.Base(args[0]), ".exe")] {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// expandBrowserArg replaces %s in a word of a browser command with url and
+// %% with %, in one pass so that the percent escapes in url are kept, and
+// reports whether there was a %s.
+func expandBrowserArg(arg, url string) (string, bool) {
+	var b strings.Builder
+	substituted := false
+	for i := 0; i < len(arg); i++ {
+		if arg[i] == '%' && i+1 < len(arg) {
+			switch arg[i+1] {
+			case 's':
+				b.WriteString(url)
+				substituted = true
+				i++
+				continue
+			case '%':
+				b.WriteByte('%')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(arg[i])
+	}
+	return b.String(), substituted
+}
+
+// splitCommand splits a command line into words, honouring single and
+// double quotes and, except on Windows, backslash escapes.
+func splitCommand(s string) []string {
+	var args []string
+	var b strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			b.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'' && runtime.GOOS != "windows":
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				b.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				args = append(args, b.String())
+				b.Reset()
+				inWord = false
+			}
+		default:
+			b.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, b.String())
+	}
+	return args
+}
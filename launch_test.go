@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestExpandBrowserArg(t *testing.T) {
+	const u = "file:///tmp/a%20b.html#x%25"
+	tests := []struct {
+		arg, want string
+		ok        bool
+	}{
+		{arg: "%s", want: u, ok: true},
+		{arg: "--app=%s", want: "--app=" + u, ok: true},
+		{arg: "100%%", want: "100%"},
+		{arg: "%%s", want: "%s"},
+		{arg: "%%%s", want: "%" + u, ok: true},
+		{arg: "--new-window", want: "--new-window"},
+		{arg: "50%", want: "50%"},
+	}
+	for _, tt := range tests {
+		got, ok := expandBrowserArg(tt.arg, u)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("expandBrowserArg(%q) = %q, %v, want %q, %v", tt.arg, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -9,8 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	"github.com/pkg/browser"
+	"time"
 	"gitlab.com/golang-commonmark/markdown"
 )
 
@@ -24,12 +23,36 @@ func main() {
 	var filepathPtr = flag.Bool("filepath", false, "Output filepath instead of html on pipe/redirect")
 	var xhtmlPtr = flag.Bool("xhtml", false, "Choose XHTML instead of HTML")
 	var darkPtr = flag.Bool("dark", false, "Darkmode")
+	var servePtr = flag.Bool("serve", false, "Browse a directory (or file) through a local preview server.")
+	var addrPtr = flag.String("addr", "localhost:0", "Address the preview server listens on.")
+	var daemonPtr = flag.Bool("daemon", os.Getenv("MDVIEW_DAEMON") != "", "Reuse a background preview server and its browser tab.")
+	var noDaemonPtr = flag.Bool("no-daemon", false, "Write a temp file and open a new tab even if -daemon or $MDVIEW_DAEMON is set.")
+	var templatePtr = flag.String("template", "", "Page template file (Go text/template) to use instead of the built-in one.")
+	flag.StringVar(&browserCommand, "browser", "", "Browser preset (chromium-app, firefox-kiosk, ...) or command, %s marks the page. Defaults to $BROWSER.")
+	flag.BoolVar(&noOpen, "no-open", false, "Print the output path (or URL) instead of opening a browser.")
+	var footnotePopupsPtr = flag.Bool("footnote-popups", false, "Show footnotes in a popover when hovering over their references.")
+	var emojiPtr = flag.String("emoji", "", "How to render emoji shortcodes such as :rocket:: unicode, span or none. Defaults to unicode.")
+	var extensionsPtr = flag.String("extensions", "", "Comma separated markdown extensions to enable: definition-lists, abbreviations, subscript, superscript, highlight, all or none. Overrides the configuration file.")
+	var foldPtr = flag.Bool("fold", false, "Let sections be folded under their headings, with expand all and collapse all controls.")
+	var figureNumbersPtr = flag.Bool("figure-numbers", false, "Number the captions of figures.")
+	var sectionNumbersPtr = flag.Bool("section-numbers", false, "Number headings hierarchically, as in 3.2.1.")
+	var sectionNumbersFromPtr = flag.Int("section-numbers-from", 0, "Heading level section numbering starts at. Defaults to 1.")
+	var bibliographyPtr = flag.String("bibliography", "", "BibTeX (.bib) or CSL-JSON (.json) file to resolve citations such as [@knuth1984] against, instead of the one named in the front matter.")
+	var citationStylePtr = flag.String("citation-style", "", "How to cite and list works: author-year or numeric. Overrides the front matter; defaults to author-year.")
+	var execPtr = flag.Bool("exec", false, "Run the code of fences marked {exec} and show its output. Only use on documents you trust.")
+	var vars varFlag
+	flag.Var(&vars, "var", "Set a variable for {{ .name }} and <!-- if --> conditions, as in -var edition=enterprise. May be repeated; overrides the front matter and $MDVIEW_VAR_name.")
+	var sectionPtr = flag.String("section", "", "Render only the section under this heading, given by its text or id, as in -section Installation.")
+	var strictPtr = flag.Bool("strict", false, "Fail when a file the document includes or quotes a snippet from is missing, or lacks the lines or region quoted.")
+	var configPtr = flag.String("config", "", "Configuration file. Defaults to mdview/config.toml in the user's configuration directory.")
+	var idlePtr = flag.Duration("idle", 10*time.Minute, "How long the daemon waits without requests or open tabs before exiting.")
 	flag.BoolVar(versionPtr, "v", false, "Prints mdview version.")
 	flag.BoolVar(helpPtr, "h", false, "Prints mdview help message.")
 	flag.BoolVar(barePtr, "b", false, "Bare HTML with no style applied.")
 	flag.BoolVar(filepathPtr, "f", false, "Output filelocation in pipe/redirect")
 	flag.BoolVar(xhtmlPtr, "x", false, "Choose XHTML instead of HTML")
 	flag.BoolVar(darkPtr, "d", false, "Darkmode")
+	flag.BoolVar(servePtr, "s", false, "Browse a directory (or file) through a local preview server.")
 
 	flag.Parse()
 	inputFilename := flag.Arg(0)
@@ -39,29 +62,115 @@ func main() {
 		os.Exit(0)
 	}
 
+	// setup creates the renderer asked for by the configuration file and
+	// the options, which take precedence.
+	setup := func() *renderer {
+		r := newRenderer(*xhtmlPtr, *barePtr, *darkPtr)
+		r.footnotePopups = *footnotePopupsPtr
+		r.strict = *strictPtr
+		r.exec = *execPtr
+		cfg, err := loadConfig(*configPtr)
+		check(err)
+		check(r.configure(cfg))
+		if *emojiPtr != "" {
+			r.emoji, err = parseEmojiMode(*emojiPtr)
+			check(err)
+		}
+		if *extensionsPtr != "" {
+			exts, err := parseExtensions(*extensionsPtr)
+			check(err)
+			r.setExtensions(exts)
+		}
+		// Switches given on the command line override the configuration
+		// either way.
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "fold":
+				r.fold = *foldPtr
+			case "figure-numbers":
+				r.figureNumbers = *figureNumbersPtr
+			case "section-numbers":
+				r.sectionNumbers = *sectionNumbersPtr
+			}
+		})
+		if *sectionNumbersFromPtr != 0 {
+			if *sectionNumbersFromPtr < 1 || *sectionNumbersFromPtr > 6 {
+				check(fmt.Errorf("section-numbers-from: %d is not a heading level", *sectionNumbersFromPtr))
+			}
+			r.sectionNumbersFrom = *sectionNumbersFromPtr
+		}
+		if *bibliographyPtr != "" {
+			r.bibliography, err = filepath.Abs(*bibliographyPtr)
+			check(err)
+		}
+		if len(vars) > 0 {
+			r.vars = make(map[string]string)
+			for _, kv := range vars {
+				eq := strings.IndexByte(kv, '=')
+				r.vars[kv[:eq]] = kv[eq+1:]
+			}
+		}
+		if *citationStylePtr != "" {
+			r.citationStyle, err = parseCitationStyle(*citationStylePtr)
+			check(err)
+		}
+		if *templatePtr != "" {
+			check(r.loadTemplate(*templatePtr))
+		}
+		return r
+	}
+
+	if os.Getenv(daemonChildEnv) != "" {
+		check(runDaemon(setup(), os.Args[1:], *idlePtr))
+		return
+	}
+
+	if *servePtr && inputFilename == "" {
+		inputFilename = "."
+	}
+
 	if inputFilename == "" || *helpPtr {
-		os.Stderr.WriteString("Usage:\nmdview [options] <filename>\nFormats markdown and launches it in a browser.\n")
+		os.Stderr.WriteString("Usage:\nmdview [options] <filename>\nmdview -serve [options] [directory]\nFormats markdown and launches it in a browser.\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	dat, err := ioutil.ReadFile(inputFilename)
-	check(err)
+	// The server renders whatever document in the directory is asked for,
+	// so it would run code nobody chose to run.
+	if *servePtr && *execPtr {
+		fmt.Fprintln(os.Stderr, "mdview: -exec cannot be used with -serve")
+		os.Exit(1)
+	}
+
+	inputFilename, loc := splitLocation(inputFilename)
+	r := setup()
+
+	if *servePtr {
+		check(serve(inputFilename, loc, *addrPtr, r))
+		return
+	}
 
-	var htmlorxhtml =  markdown.HTML(true)
-	if *xhtmlPtr {
-		htmlorxhtml = markdown.XHTMLOutput(true)
+	// The daemon serves every later mdview, so it never runs code: with
+	// -exec the page is rendered here instead.
+	if *daemonPtr && !*noDaemonPtr && *outfilePtr == "" && *sectionPtr == "" && !*execPtr && (isTerminal() || noOpen) {
+		args, err := daemonArgs(flag.CommandLine)
+		check(err)
+		if err = openInDaemon(inputFilename, loc, args); err == nil {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "mdview: daemon unavailable:", err)
 	}
 
-	md := markdown.New(
-		htmlorxhtml,
-		markdown.Nofollow(true),
-		markdown.Tables(true),
-		markdown.Typographer(true))
+	dat, err := ioutil.ReadFile(inputFilename)
+	check(err)
 
-	markdownTokens := md.Parse(dat)
-	html := md.RenderTokensToString(markdownTokens)
-	title := getTitle(markdownTokens)
+	r.target = loc
+	r.section = *sectionPtr
+	p, err := r.render(inputFilename, dat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mdview:", err)
+		os.Exit(1)
+	}
 
 	outfilePath := *outfilePtr
 	if outfilePath == "" {
@@ -71,21 +180,13 @@ func main() {
 	f, err := os.Create(outfilePath)
 	check(err)
 	defer f.Close()
-	actualStyle := style
-	if *barePtr {
-		actualStyle = ""
-	}
-	if *darkPtr {
-		actualStyle = darkstyle
-	}
-	_, err = fmt.Fprintf(f, template, actualStyle, title, html)
+	_, err = f.WriteString(r.execute(p))
 	check(err)
 	f.Sync()
 
-  o, _ := os.Stdout.Stat()
-  if (o.Mode() & os.ModeCharDevice) == os.ModeCharDevice { //Terminal
+  if isTerminal() || noOpen { //Terminal
     //Display info to the terminal
-    err = browser.OpenFile(outfilePath)
+    err = openPath(outfilePath)
     check(err)
   } else { //It is not the terminal
     // Display info to a pipe
@@ -93,12 +194,19 @@ func main() {
     if *filepathPtr {
       _, err = fmt.Printf(outfilePath)
     } else {
-      _, err = fmt.Printf(template, actualStyle, title, html)
+      _, err = fmt.Print(r.execute(p))
     }
     check(err)
   }
 }
 
+// isTerminal reports whether stdout is a terminal rather than a pipe or
+// redirect.
+func isTerminal() bool {
+	o, _ := os.Stdout.Stat()
+	return (o.Mode() & os.ModeCharDevice) == os.ModeCharDevice
+}
+
 func tempFileName(prefix, suffix string) string {
 	randBytes := make([]byte, 16)
 	rand.Read(randBytes)
@@ -157,7 +265,7 @@ func getText(token markdown.Token) string {
 
 }
 
-const template = "<!DOCTYPE html><html><head><meta http-equiv=\"content-type\" content=\"text/html; charset=utf-8\"> <style>%s</style><title>%s</title></head><body class=\"markdown-body\">%s</body></html>"
+const pageTemplate = "<!DOCTYPE html><html><head><meta http-equiv=\"content-type\" content=\"text/html; charset=utf-8\"> <style>{{.Style}}</style><title>{{.Title}}</title></head><body class=\"markdown-body\">{{.Body}}</body></html>"
 
 const style = `.markdown-body {box-sizing: border-box;min-width: 200px;max-width:
 	 	980px;margin: 0 auto;padding: 45px;}	@media (max-width: 767px) {.markdown-body
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func init() {
+	// After strikethrough, which leaves single tildes alone.
+	markdown.RegisterInlineRule(550, ruleSubscript)
+	markdown.RegisterInlineRule(560, ruleSuperscript)
+	markdown.RegisterInlineRule(570, ruleHighlight)
+}
+
+// ruleSubscript parses subscripts such as H~2~O.
+func ruleSubscript(s *markdown.StateInline, silent bool) bool {
+	return enabled(s.Md, extSubscript) && script(s, silent, '~', "sub")
+}
+
+// ruleSuperscript parses superscripts such as x^2^.
+func ruleSuperscript(s *markdown.StateInline, silent bool) bool {
+	return enabled(s.Md, extSuperscript) && script(s, silent, '^', "sup")
+}
+
+var scriptEscape = regexp.MustCompile(`\\([ \\!"#$%&'()*+,./:;<=>?@[\]^_` + "`" + `{|}~-])`)
+
+// script parses text between two markers, which may not contain spaces
+// unless they are escaped, into the element tag. Like markdown-it-sub and
+// markdown-it-sup, the text is not parsed any further.
+func script(s *markdown.StateInline, silent bool, marker byte, tag string) bool {
+	start, max := s.Pos, s.PosMax
+	if silent || s.Src[start] != marker || start+2 >= max {
+		return false
+	}
+
+	s.Pos = start + 1
+	found := false
+	for s.Pos < max {
+		if s.Src[s.Pos] == marker {
+			found = true
+			break
+		}
+		s.Md.Inline.SkipToken(s)
+	}
+	end := s.Pos
+	s.Pos = start
+	if !found || end == start+1 {
+		return false
+	}
+	content := s.Src[start+1 : end]
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case ' ', '\t', '\n':
+			return false
+		}
+	}
+
+	s.PushToken(&markdown.HTMLInline{Content: "<" + tag + ">"})
+	s.PushToken(&markdown.Text{Content: scriptEscape.ReplaceAllString(content, "$1")})
+	s.PushToken(&markdown.HTMLInline{Content: "</" + tag + ">"})
+	s.Pos = end + 1
+	return true
+}
+
+// ruleHighlight parses ==highlighted text== into a <mark> element. The
+// text may contain further markup. Like emphasis, the opening == must be
+// followed and the closing == preceded by something other than a space.
+func ruleHighlight(s *markdown.StateInline, silent bool) bool {
+	start, max := s.Pos, s.PosMax
+	if silent || !enabled(s.Md, extHighlight) || !strings.HasPrefix(s.Src[start:max], "==") {
+		return false
+	}
+	if start+2 >= max || s.Src[start+2] == '=' || isSpace(s.Src[start+2]) {
+		return false
+	}
+
+	s.Pos = start + 2
+	found := false
+	for s.Pos < max {
+		if strings.HasPrefix(s.Src[s.Pos:max], "==") && !isSpace(s.Src[s.Pos-1]) {
+			found = true
+			break
+		}
+		s.Md.Inline.SkipToken(s)
+	}
+	end := s.Pos
+	s.Pos = start
+	if !found {
+		return false
+	}
+
+	s.PushToken(&markdown.HTMLInline{Content: "<mark>"})
+	s.Pos, s.PosMax = start+2, end
+	s.Md.Inline.Tokenize(s)
+	s.PushToken(&markdown.HTMLInline{Content: "</mark>"})
+	s.Pos, s.PosMax = end+2, max
+	return true
+}
+
+func isSpace(c byte) bool { return c < 0x80 && unicode.IsSpace(rune(c)) }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func init() {
+	markdown.RegisterBlockRule(320, ruleMathBlock, []int{1100, 700, 400, 600})
+	markdown.RegisterInlineRule(350, ruleMath)
+}
+
+// ruleMathBlock parses display math set off by lines starting and ending
+// with $$, such as
+//
+//	$$
+//	\sum_{i=1}^n i = \frac{n(n+1)}{2}
+//	$$
+//
+// The MathML is produced while parsing, so the typographer never sees the
+// TeX source.
+func ruleMathBlock(s *markdown.StateBlock, startLine, endLine int, silent bool) bool {
+	if s.SCount[startLine]-s.BlkIndent >= 4 {
+		return false
+	}
+	line := strings.TrimSpace(s.Src[s.BMarks[startLine]+s.TShift[startLine] : s.EMarks[startLine]])
+	if !strings.HasPrefix(line, "$$") {
+		return false
+	}
+	first := line[2:]
+
+	var tex string
+	nextLine := startLine
+	switch {
+	case strings.HasSuffix(first, "$$"):
+		tex = first[:len(first)-2]
+	case strings.Contains(first, "$$"):
+		return false // inline math at the start of a paragraph
+	default:
+		lines := []string{first}
+		for {
+			nextLine++
+			if nextLine >= endLine {
+				return false
+			}
+			pos, max := s.BMarks[nextLine]+s.TShift[nextLine], s.EMarks[nextLine]
+			if pos < max && s.SCount[nextLine] < s.BlkIndent {
+				return false
+			}
+			text := strings.TrimSpace(s.Src[pos:max])
+			if strings.HasSuffix(text, "$$") {
+				lines = append(lines, text[:len(text)-2])
+				break
+			}
+			lines = append(lines, text)
+		}
+		tex = strings.Join(lines, "\n")
+	}
+	if strings.TrimSpace(tex) == "" && nextLine == startLine {
+		return false
+	}
+	if silent {
+		return true
+	}
+
+	s.Line = nextLine + 1
+	s.PushToken(&markdown.HTMLBlock{
+		Content: texToMathML(strings.TrimSpace(tex), true) + "\n",
+		Map:     [2]int{startLine, s.Line},
+	})
+	return true
+}
+
+// ruleMath parses inline math, $...$, and display math written inline,
+// $$...$$. Like Pandoc, it requires the opening $ to be followed and the
+// closing $ to be preceded by a non-space, and the closing $ not to be
+// followed by a digit, so that prices such as $5 and $10 stay as text.
+// Neither may touch a letter or digit outside the math either.
+func ruleMath(s *markdown.StateInline, silent bool) bool {
+	src := s.Src[s.Pos:s.PosMax]
+	if !strings.HasPrefix(src, "$") {
+		return false
+	}
+	if s.Pos > 0 && isAlphanumeric(s.Src[s.Pos-1]) {
+		return false
+	}
+
+	display := strings.HasPrefix(src, "$$")
+	delim := "$"
+	if display {
+		delim = "$$"
+	}
+	start := len(delim)
+	if start >= len(src) || !display && (src[start] == ' ' || src[start] == '\t' || src[start] == '\n') {
+		return false
+	}
+
+	end := -1
+	for i := start; i < len(src); i++ {
+		switch {
+		case src[i] == '\\':
+			i++
+		case strings.HasPrefix(src[i:], delim):
+			if display {
+				end = i
+				break
+			}
+			prev := src[i-1]
+			if i == start || prev == ' ' || prev == '\t' || prev == '\n' {
+				continue
+			}
+			if i+1 < len(src) && isAlphanumeric(src[i+1]) {
+				continue
+			}
+			end = i
+		}
+		if end >= 0 {
+			break
+		}
+	}
+	if end < 0 || strings.TrimSpace(src[start:end]) == "" {
+		return false
+	}
+
+	if !silent {
+		s.PushToken(&markdown.HTMLInline{Content: texToMathML(strings.TrimSpace(src[start:end]), display)})
+	}
+	s.Pos += end + len(delim)
+	return true
+}
+
+func isAlphanumeric(c byte) bool { return isASCIILetter(c) || isDigit(c) }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+//go:generate go run gen_mermaid.go
+
+// mermaid marks the document's ```mermaid fences to be drawn as diagrams
+// and reports whether there are any. A binary built without the runtime,
+// see gen_mermaid.go, leaves them as code and says so.
+func (d *document) mermaid() bool {
+	found := false
+	for _, tok := range d.tokens {
+		fence, ok := tok.(*markdown.Fence)
+		if !ok {
+			continue
+		}
+		if fields := strings.Fields(fence.Params); len(fields) > 0 && fields[0] == "mermaid" {
+			if mermaidRuntime == "" {
+				fmt.Fprintln(os.Stderr, "mdview: built without the mermaid runtime, showing mermaid diagrams as code")
+				return false
+			}
+			d.setAttr(fence, "class", "mermaid")
+			found = true
+		}
+	}
+	return found
+}
+
+// mermaidScript returns the script that draws a page's mermaid diagrams:
+// the bundled runtime itself, so that pages work offline and from file://
+// URLs, followed by its setup for the page's theme.
+func mermaidScript(t *theme) string {
+	name := "default"
+	if t != nil && t.name == "dark" {
+		name = "dark"
+	}
+	// The runtime must not end the script element early.
+	runtime := strings.Replace(mermaidRuntime, "</script", `<\/script`, -1)
+	return "<script>" + runtime + "</script>\n" +
+		`<script>mermaid.initialize({startOnLoad:true,theme:"` + name + `",securityLevel:"strict"});</script>`
+}
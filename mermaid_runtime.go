@@ -0,0 +1,9 @@
+package main
+
+// mermaidVersion and mermaidRuntime hold the bundled mermaid library. They
+// are filled in by `go generate`, see gen_mermaid.go; a binary built
+// without running it shows mermaid blocks as code.
+const (
+	mermaidVersion = ""
+	mermaidRuntime = ""
+)
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// renderer turns markdown source into HTML pages using the options given
+// on the command line.
+type renderer struct {
+	md    *markdown.Markdown
+	style string
+	theme *theme // nil for bare HTML
+	tmpl  *template.Template
+	xhtml bool
+	// lines adds data-source-line attributes to block elements so that
+	// the preview can be scrolled in step with an editor.
+	lines bool
+	// interactive enables task list checkboxes, which the preview server
+	// writes back to the source file.
+	interactive bool
+	// footnotePopups shows footnotes in a popover when hovering over
+	// their references.
+	footnotePopups bool
+	// filters maps fence languages to the commands that render them.
+	filters       map[string]string
+	filterTimeout time.Duration
+	// exec runs the code of {exec} fences as execution says.
+	exec      bool
+	execution execution
+	// emoji is how emoji shortcodes are rendered, see parseEmojiMode.
+	emoji string
+	// fold lets the reader fold sections away under their headings.
+	fold bool
+	// figureNumbers numbers the captions of figures and tables.
+	figureNumbers bool
+	// sectionNumbers numbers headings of level sectionNumbersFrom and
+	// below.
+	sectionNumbers     bool
+	sectionNumbersFrom int
+	// bibliography is the BibTeX or CSL-JSON file citations are resolved
+	// against, instead of the one a document's front matter names.
+	bibliography string
+	// vars are the variables set with -var, see preprocess.
+	vars map[string]string
+	// citationStyle overrides the citation style a document's front
+	// matter sets, see parseCitationStyle.
+	citationStyle string
+	// section is the heading text or id of the only section to render,
+	// see document.section.
+	section string
+	// strict fails the render when a file the document includes or
+	// quotes a snippet from cannot be read.
+	strict bool
+	// root, when set, is the directory that files the document includes
+	// or quotes snippets from must be in, as the preview server's are.
+	root string
+	// target is where a static page scrolls to once loaded.
+	target location
+}
+
+func newRenderer(xhtml, bare, dark bool) *renderer {
+	var htmlorxhtml = markdown.HTML(true)
+	if xhtml {
+		htmlorxhtml = markdown.XHTMLOutput(true)
+	}
+
+	actualStyle, actualTheme := style, lightTheme
+	if bare {
+		actualStyle, actualTheme = "", nil
+	}
+	if dark {
+		actualStyle, actualTheme = darkstyle, darkTheme
+	}
+
+	return &renderer{
+		md: markdown.New(
+			htmlorxhtml,
+			markdown.Nofollow(true),
+			markdown.Tables(true),
+			markdown.Typographer(true)),
+		style: actualStyle,
+		theme: actualTheme,
+		tmpl:  template.Must(template.New("page").Parse(pageTemplate)),
+		xhtml: xhtml,
+		emoji: emojiUnicode,
+
+		execution: execution{env: defaultExecEnv, timeout: 10 * time.Second},
+
+		sectionNumbersFrom: 1,
+	}
+}
+
+// page is the data a page template is executed with.
+type page struct {
+	Style string
+	Title string
+	Body  string
+	// Tasks counts the document's task list items.
+	Tasks taskSummary
+	// deps are the other files the page was rendered from, such as those
+	// it includes.
+	deps []string
+}
+
+// render converts src, the contents of the file name, to an HTML page
+// body. Files the document refers to, such as its bibliography, are found
+// relative to name. Files that cannot be included or quoted from are
+// reported on stderr, or in strict mode by the error returned along with
+// the page, as is a section to render that cannot be found.
+func (r *renderer) render(name string, src []byte) (*page, error) {
+	meta, src := frontMatter(src)
+	vars := r.variables(meta)
+	src, problems := preprocess(src, vars)
+	var warnings []string
+	for _, p := range problems {
+		warnings = append(warnings, filepath.Base(name)+":"+p)
+	}
+	doc := &document{tokens: r.md.Parse(src)}
+	deps, failures := doc.includes(r.md, name, r.root, vars)
+	quoted, errs := snippets(doc.tokens, name, r.root)
+	deps, failures = append(deps, quoted...), append(failures, errs...)
+	var err error
+	if r.strict && len(failures) > 0 {
+		err = errors.New(strings.Join(failures, "\n"))
+	} else {
+		warnings = append(warnings, failures...)
+	}
+	doc.headingIDs()
+	title := getTitle(doc.tokens)
+	if t := meta.str("title"); t != "" {
+		title = html.EscapeString(substitute(t, vars))
+	}
+	// Numbering comes before cutting out a section, so that it keeps the
+	// numbers it has in the whole document.
+	doc.figures(r.figureNumbers, r.xhtml)
+	doc.tableCaptions(r.figureNumbers)
+	from := 0
+	if r.sectionNumbers {
+		from = r.sectionNumbersFrom
+	}
+	doc.sectionNumbers(from)
+	if r.section != "" {
+		if t, ok := doc.section(r.section); ok {
+			title = html.EscapeString(t)
+		} else if err == nil {
+			err = fmt.Errorf("no section %q in %s", r.section, filepath.Base(name))
+		}
+	}
+	if r.emoji != emojiNone {
+		title = emojiTitle(title)
+	}
+	refs, style, bibs, problems := r.references(name, meta)
+	deps = append(deps, bibs...)
+	warnings = append(warnings, problems...)
+	if refs != nil {
+		for _, key := range doc.citations(refs, style, meta.list("nocite")) {
+			warnings = append(warnings, "unknown citation key "+key)
+		}
+	}
+	if r.exec {
+		warnings = append(warnings, doc.execute(name, &r.execution)...)
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "mdview:", w)
+	}
+	doc.abbreviations()
+	doc.emoji(r.emoji)
+	doc.alerts()
+	for _, ref := range doc.crossReferences() {
+		fmt.Fprintln(os.Stderr, "mdview: dangling reference", ref)
+	}
+	doc.filters(r.filters, r.filterTimeout, r.xhtml)
+	doc.diagrams(r.theme)
+	doc.charts(r.theme)
+	hasFootnotes := doc.footnotes()
+	hasDiagrams := doc.mermaid()
+	tasks := doc.taskLists(r.xhtml, r.interactive)
+	if r.lines || r.target.line > 0 {
+		doc.sourceLines()
+	}
+	body := r.md.RenderTokensToString(doc.finish(r.xhtml))
+	if hasDiagrams {
+		body += mermaidScript(r.theme)
+	}
+	if hasFootnotes && r.footnotePopups {
+		body += footnotePopupScript
+	}
+	if r.fold {
+		// Static pages remember folded sections in local storage by file
+		// rather than URL. Served pages keep them on the server instead,
+		// see foldStoreScript.
+		key, err := filepath.Abs(name)
+		if err != nil {
+			key = name
+		}
+		body += foldScript(key)
+	}
+	if r.target != (location{}) {
+		body += gotoScript(r.target)
+	}
+	return &page{Title: title, Body: body, Tasks: tasks, deps: deps}, err
+}
+
+// loadTemplate replaces the built-in page template with the one in name.
+func (r *renderer) loadTemplate(name string) error {
+	t, err := template.ParseFiles(name)
+	if err != nil {
+		return err
+	}
+	r.tmpl = t
+	return nil
+}
+
+// execute renders p as a complete, styled HTML document.
+func (r *renderer) execute(p *page) string {
+	p.Style = r.style
+	if r.theme != nil {
+		p.Style += extraStyle + r.theme.css()
+	}
+	var b strings.Builder
+	if err := r.tmpl.Execute(&b, p); err != nil {
+		return "<!-- template error: " + html.EscapeString(err.Error()) + " -->" + b.String()
+	}
+	return b.String()
+}
+
+// attribute is a single HTML attribute added to a rendered block element.
+type attribute struct {
+	name, value string
+}
+
+// document is a parsed markdown file together with the extra attributes
+// the rendering passes attach to its block tokens.
+type document struct {
+	tokens []markdown.Token
+	attrs  map[markdown.Token][]attribute
+	// targets holds the text of cross-references to sections, figures
+	// and tables by id.
+	targets map[string]string
+	// included holds the tokens spliced in from other files, whose
+	// source lines are not the document's.
+	included map[markdown.Token]bool
+}
+
+func (d *document) setAttr(tok markdown.Token, name, value string) {
+	if d.attrs == nil {
+		d.attrs = make(map[markdown.Token][]attribute)
+	}
+	for i, a := range d.attrs[tok] {
+		if a.name == name {
+			d.attrs[tok][i].value = value
+			return
+		}
+	}
+	d.attrs[tok] = append(d.attrs[tok], attribute{name, value})
+}
+
+// insert adds toks to the token stream before index i.
+func (d *document) insert(i int, toks ...markdown.Token) {
+	d.tokens = append(d.tokens[:i], append(toks, d.tokens[i:]...)...)
+}
+
+// headingIDs gives every heading a GitHub-style id so that links such as
+// README.md#installation land on the right section. An attribute block at
+// the end of a heading, as in "## Error handling {#errors}", sets its id
+// and classes instead.
+func (d *document) headingIDs() {
+	seen := make(map[string]int)
+	for i, tok := range d.tokens {
+		if _, ok := tok.(*markdown.HeadingOpen); !ok || i+1 >= len(d.tokens) {
+			continue
+		}
+		if attrs := trailingAttributes(d.tokens[i+1]); attrs != nil {
+			for _, a := range attrs {
+				d.setAttr(tok, a.name, a.value)
+			}
+			if hasAttr(attrs, "id") {
+				continue
+			}
+		}
+		slug := slugify(getText(d.tokens[i+1]))
+		if n := seen[slug]; n > 0 {
+			seen[slug] = n + 1
+			slug += "-" + strconv.Itoa(n)
+		} else {
+			seen[slug] = 1
+		}
+		d.setAttr(tok, "id", slug)
+	}
+}
+
+// trailingAttributes removes the attribute block ending the inline content
+// of a heading or caption and returns its attributes.
+func trailingAttributes(tok markdown.Token) []attribute {
+	inline, ok := tok.(*markdown.Inline)
+	if !ok || len(inline.Children) == 0 {
+		return nil
+	}
+	text, ok := inline.Children[len(inline.Children)-1].(*markdown.Text)
+	if !ok {
+		return nil
+	}
+	content := strings.TrimRight(text.Content, " \t")
+	start := strings.LastIndexByte(content, '{')
+	if start < 0 || start > 0 && content[start-1] != ' ' {
+		return nil
+	}
+	attrs, n := parseAttributes(content[start:])
+	if n != len(content)-start {
+		return nil
+	}
+	text.Content = strings.TrimRight(content[:start], " ")
+	if end := strings.LastIndexByte(inline.Content, '{'); end >= 0 {
+		inline.Content = strings.TrimRight(inline.Content[:end], " ")
+	}
+	return attrs
+}
+
+// sourceLines records on every block element the (1-based) line of the
+// markdown source it starts on.
+func (d *document) sourceLines() {
+	for _, tok := range d.tokens {
+		if d.included[tok] {
+			continue
+		}
+		if line, ok := sourceLine(tok); ok {
+			d.setAttr(tok, "data-source-line", strconv.Itoa(line))
+		}
+	}
+}
+
+// sourceLine returns the 1-based line tok starts on, when the parser
+// recorded one.
+func sourceLine(tok markdown.Token) (int, bool) {
+	var m [2]int
+	switch tok := tok.(type) {
+	case *markdown.ParagraphOpen:
+		if tok.Tight || tok.Hidden {
+			return 0, false
+		}
+		m = tok.Map
+	case *markdown.HeadingOpen:
+		m = tok.Map
+	case *markdown.BlockquoteOpen:
+		m = tok.Map
+	case *markdown.BulletListOpen:
+		m = tok.Map
+	case *markdown.OrderedListOpen:
+		m = tok.Map
+	case *markdown.ListItemOpen:
+		m = tok.Map
+	case *markdown.TableOpen:
+		m = tok.Map
+	case *markdown.TrOpen:
+		m = tok.Map
+	case *markdown.Hr:
+		m = tok.Map
+	case *markdown.Fence:
+		m = tok.Map
+	case *markdown.CodeBlock:
+		m = tok.Map
+	default:
+		return 0, false
+	}
+	return m[0] + 1, true
+}
+
+// slugify turns heading text into an anchor name the same way GitHub does:
+// lower case, punctuation dropped and spaces replaced by hyphens.
+func slugify(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case unicode.IsLetter(c), unicode.IsDigit(c), c == '-', c == '_':
+			b.WriteRune(c)
+		case c == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// finish returns the token stream with every attributed opening token
+// replaced by the equivalent raw HTML tag.
+func (d *document) finish(xhtml bool) []markdown.Token {
+	if len(d.attrs) == 0 {
+		return d.tokens
+	}
+	tokens := make([]markdown.Token, len(d.tokens))
+	for i, tok := range d.tokens {
+		tokens[i] = tok
+		if attrs, ok := d.attrs[tok]; ok {
+			if tag := tagHTML(tok, attrs, xhtml); tag != "" {
+				tokens[i] = &markdown.HTMLBlock{Content: tag, Lvl: tok.Level()}
+			}
+		}
+	}
+	return tokens
+}
+
+// tagHTML renders the opening HTML of tok with attrs added, or returns ""
+// when tok is not an element that can carry attributes. Code blocks have
+// no separate closing token and are rendered in full.
+func tagHTML(tok markdown.Token, attrs []attribute, xhtml bool) string {
+	var tag string
+	switch tok := tok.(type) {
+	case *markdown.Fence:
+		for _, a := range attrs {
+			if a.name == "class" && a.value == "mermaid" {
+				// Mermaid reads the diagram from the element's text.
+				return "<pre" + formatAttrs(attrs) + ">" + html.EscapeString(tok.Content) + "</pre>\n"
+			}
+		}
+		var class string
+		if fields := strings.Fields(tok.Params); len(fields) > 0 {
+			class = " class=\"language-" + html.EscapeString(fields[0]) + "\""
+		}
+		return "<pre" + formatAttrs(attrs) + "><code" + class + ">" + html.EscapeString(tok.Content) + "</code></pre>\n"
+	case *markdown.CodeBlock:
+		return "<pre" + formatAttrs(attrs) + "><code>" + html.EscapeString(tok.Content) + "</code></pre>\n"
+	case *markdown.ParagraphOpen:
+		if tok.Tight || tok.Hidden {
+			return ""
+		}
+		tag = "p"
+	case *markdown.OrderedListOpen:
+		if tok.Order != 1 {
+			attrs = append([]attribute{{"start", strconv.Itoa(tok.Order)}}, attrs...)
+		}
+		tag = "ol"
+	case *markdown.HeadingOpen, *markdown.BlockquoteOpen, *markdown.BulletListOpen,
+		*markdown.ListItemOpen, *markdown.TableOpen, *markdown.TrOpen:
+		tag = tok.Tag()
+	case *markdown.Hr:
+		tag = "hr"
+	default:
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("<" + tag + formatAttrs(attrs))
+	if _, ok := tok.(*markdown.Hr); ok && xhtml {
+		b.WriteString(" />")
+	} else {
+		b.WriteString(">")
+	}
+	switch tok.(type) {
+	case *markdown.ParagraphOpen, *markdown.HeadingOpen, *markdown.ListItemOpen:
+	default:
+		// The markdown renderer puts container tags on a line of their own.
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func formatAttrs(attrs []attribute) string {
+	var b strings.Builder
+	for _, a := range attrs {
+		fmt.Fprintf(&b, " %s=\"%s\"", a.name, html.EscapeString(a.value))
+	}
+	return b.String()
+}
+
+// extraStyle styles the elements mdview adds on top of GitHub's markdown
+// output. It only uses inherited colours so that it suits every theme.
+const extraStyle = `.markdown-body .mdview-path{font-weight:600}
+	.markdown-body .mdview-files{display:table;width:100%}.markdown-body .mdview-files td{border:0;border-top:1px solid rgba(128,128,128,.3)}
+	.markdown-body .mdview-files td+td{text-align:right;opacity:.7;white-space:nowrap}
+	.markdown-body .mdview-source{display:table;width:100%}.markdown-body .mdview-source td{border:0;padding:0;vertical-align:top}
+	.markdown-body .mdview-source pre{margin:0;border-radius:0}.markdown-body .mdview-lines pre{text-align:right;user-select:none;padding-right:8px}
+	.markdown-body .mdview-lines a{color:inherit;opacity:.5}.markdown-body .mdview-source tr{background-color:transparent}
+	.markdown-body .footnotes{font-size:12px;opacity:.85}.markdown-body .footnote-ref a{scroll-margin-top:16px}
+	.markdown-body .footnote-backref{text-decoration:none}.markdown-body .footnotes li:target{outline:2px solid rgba(128,128,128,.4)}
+	.markdown-body math[display=block]{margin:16px 0;overflow-x:auto;overflow-y:hidden}
+	.markdown-body .mdview-filter{margin-bottom:16px;overflow-x:auto}.markdown-body .mdview-filter img{max-width:100%}
+	.markdown-body .mdview-diagram{margin-bottom:16px;overflow-x:auto}
+	.markdown-body .mdview-chart{margin-bottom:16px}.markdown-body .mdview-chart svg{max-width:100%;height:auto}
+	.markdown-body abbr[title]{text-decoration:underline dotted;cursor:help}
+	.markdown-body details{margin-bottom:16px}.markdown-body summary{cursor:pointer;font-weight:600}.markdown-body details[open]>summary{margin-bottom:8px}
+	.markdown-body .mdview-fold{width:1.25em;margin:0 .25em 0 -1.5em;padding:0;border:0;background:none;color:inherit;font:inherit;font-size:.75em;opacity:.5;cursor:pointer}
+	.markdown-body .mdview-fold::before{content:"\25BE"}.markdown-body .mdview-folded .mdview-fold::before{content:"\25B8"}.markdown-body .mdview-fold:hover{opacity:1}
+	.markdown-body .mdview-fold-controls{float:right;font-size:12px}.markdown-body .mdview-fold-controls button{margin-left:4px;padding:2px 8px;color:inherit;
+	background:none;border:1px solid rgba(128,128,128,.4);border-radius:6px;cursor:pointer}
+	.markdown-body figure{margin:0 0 16px}.markdown-body figcaption{margin-top:8px;font-size:85%;opacity:.8}.markdown-body .figure-number{font-weight:600}
+	.markdown-body caption{padding-bottom:8px;font-size:85%;text-align:left;opacity:.8}.markdown-body .table-number{font-weight:600}
+	.markdown-body figure.center{text-align:center}.markdown-body img.center{display:block;margin:0 auto}
+	.markdown-body .left{float:left;margin-right:16px}.markdown-body .right{float:right;margin-left:16px}
+	.markdown-body .emoji{font-family:"Apple Color Emoji","Segoe UI Emoji","Segoe UI Symbol","Noto Color Emoji",sans-serif;font-style:normal;font-weight:400;line-height:1;vertical-align:-.075em}
+	.markdown-body .mdview-exec-output{border-left:.25em solid rgba(128,128,128,.4)}
+	.markdown-body .references .csl-entry{margin-bottom:8px;padding-left:2em;text-indent:-2em}`
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// section cuts the document down to the section under the heading whose
+// text or id is name, up to the next heading of the same or a higher
+// level, and returns the heading's text. Footnote and abbreviation
+// definitions elsewhere in the document are kept, so that the section's
+// references to them still resolve. It reports false, leaving the document
+// whole, when there is no such heading.
+func (d *document) section(name string) (string, bool) {
+	start, level := -1, 0
+	for i := 0; i+1 < len(d.tokens) && start < 0; i++ {
+		h, ok := d.tokens[i].(*markdown.HeadingOpen)
+		if !ok {
+			continue
+		}
+		text := strings.TrimSpace(getText(d.tokens[i+1]))
+		if id := d.attr(h, "id"); id == name || id == slugify(name) || strings.EqualFold(text, strings.TrimSpace(name)) {
+			start, level = i, h.HLevel
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+	end := len(d.tokens)
+	for i := start + 1; i < len(d.tokens); i++ {
+		if h, ok := d.tokens[i].(*markdown.HeadingOpen); ok && h.HLevel <= level {
+			end = i
+			break
+		}
+	}
+	title := strings.TrimSpace(getText(d.tokens[start+1]))
+
+	tokens := append([]markdown.Token(nil), d.tokens[start:end]...)
+	for _, part := range [][]markdown.Token{d.tokens[:start], d.tokens[end:]} {
+		tokens = append(tokens, definitions(part)...)
+	}
+	d.tokens = tokens
+	return title, true
+}
+
+// definitions returns the footnote and abbreviation definitions among
+// toks.
+func definitions(toks []markdown.Token) []markdown.Token {
+	var defs []markdown.Token
+	depth := 0
+	for _, tok := range toks {
+		b, ok := tok.(*markdown.HTMLBlock)
+		switch {
+		case ok && strings.HasPrefix(b.Content, footnoteMarker):
+			depth++
+		case ok && b.Content == footnoteEndMarker && depth > 0:
+			depth--
+			defs = append(defs, tok)
+			continue
+		case ok && depth == 0 && strings.HasPrefix(b.Content, abbrMarker):
+			defs = append(defs, tok)
+		}
+		if depth > 0 {
+			defs = append(defs, tok)
+		}
+	}
+	return defs
+}
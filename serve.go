@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSourceSize is the largest text file the server will highlight; bigger
+// files are served raw.
+const maxSourceSize = 1 << 20
+
+var readmeNames = []string{"README.md", "readme.md", "Readme.md", "README.markdown", "README"}
+
+// server previews a directory tree like GitHub's file browser: directories
+// are listed with their README rendered underneath, markdown files are
+// rendered and other text files are shown as highlighted source.
+type server struct {
+	root string
+	r    *renderer
+	hub  *hub
+	// prefix is the URL path the server is mounted at, if not "/".
+	prefix string
+}
+
+// newServer returns a server for target, which may be a directory or a
+// single file, along with the URL path that shows target.
+func newServer(target string, r *renderer, h *hub) (*server, string, error) {
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return nil, "", err
+	}
+	abs, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s := &server{root: abs, hub: h}
+	start := "/"
+	if !info.IsDir() {
+		s.root = filepath.Dir(abs)
+		start += url.PathEscape(filepath.Base(abs))
+	}
+	// Each server renders with its own copy of r, which keeps documents
+	// from including files outside its root.
+	sr := *r
+	sr.lines, sr.interactive, sr.root = true, true, s.root
+	s.r = &sr
+	return s, start, nil
+}
+
+// serve starts a preview server for target and opens it in the browser at
+// loc.
+func serve(target string, loc location, addr string, r *renderer) error {
+	s, start, err := newServer(target, r, &hub{})
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	u := "http://" + l.Addr().String() + start
+	if loc != (location{}) {
+		u += "#" + loc.fragment()
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s at %s\n", s.root, u)
+	go openURL(u)
+	return http.Serve(l, s)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !localHost(req.Host) {
+		http.Error(w, "forbidden host", http.StatusForbidden)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, apiPrefix) {
+		s.serveAPI(w, req)
+		return
+	}
+	name, err := s.resolve(req.URL.Path)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			http.Redirect(w, req, path.Base(req.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		s.serveDir(w, req, name)
+		return
+	}
+
+	if _, raw := req.URL.Query()["raw"]; raw {
+		http.ServeFile(w, req, name)
+		return
+	}
+	if isMarkdown(name) {
+		s.serveMarkdown(w, name)
+		return
+	}
+	if info.Size() <= maxSourceSize {
+		if dat, err := ioutil.ReadFile(name); err == nil && isText(dat) {
+			s.serveSource(w, req.URL.Path, name, dat)
+			return
+		}
+	}
+	http.ServeFile(w, req, name)
+}
+
+var errOutsideRoot = errors.New("path escapes the served directory")
+
+// resolve maps a URL path onto the file system, following symlinks, and
+// refuses anything that ends up outside the served root.
+func (s *server) resolve(urlPath string) (string, error) {
+	name := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+urlPath)))
+	real, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if !s.contains(real) {
+		return "", errOutsideRoot
+	}
+	return real, nil
+}
+
+func (s *server) contains(name string) bool {
+	return within(s.root, name)
+}
+
+// within reports whether name is dir or inside it.
+func within(dir, name string) bool {
+	rel, err := filepath.Rel(dir, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (s *server) serveMarkdown(w http.ResponseWriter, name string) {
+	dat, err := ioutil.ReadFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Problems are shown on the page, so strict mode has nothing to add.
+	p, _ := s.r.render(name, dat)
+	s.hub.depend(name, p.deps)
+	if p.Title == "" {
+		p.Title = html.EscapeString(filepath.Base(name))
+	}
+	folded := ""
+	if s.r.fold {
+		folded = loadFoldState(name)
+	}
+	p.Body += s.syncScript(sourceVersion(dat), folded)
+	writeHTML(w, s.r.execute(p))
+}
+
+func (s *server) serveSource(w http.ResponseWriter, urlPath, name string, dat []byte) {
+	var b strings.Builder
+	b.WriteString(s.breadcrumbs(urlPath))
+	b.WriteString(sourceView(string(dat), languageOf(name)))
+	writeHTML(w, s.r.execute(&page{Title: html.EscapeString(filepath.Base(name)), Body: b.String()}))
+}
+
+func (s *server) serveDir(w http.ResponseWriter, req *http.Request, dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IsDir() && !entries[j].IsDir()
+	})
+
+	var b strings.Builder
+	b.WriteString(s.breadcrumbs(req.URL.Path))
+	b.WriteString("<table class=\"mdview-files\">\n")
+	if dir != s.root {
+		b.WriteString("<tr><td><a href=\"../\">..</a></td><td></td></tr>\n")
+	}
+	var readme string
+	for _, e := range entries {
+		// Symlinks are shown only when their target stays inside the root.
+		info, err := os.Stat(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		if real, err := filepath.EvalSymlinks(filepath.Join(dir, e.Name())); err != nil || !s.contains(real) {
+			continue
+		}
+		href, label, size := "./"+url.PathEscape(e.Name()), html.EscapeString(e.Name()), fmt.Sprintf("%d B", info.Size())
+		if info.IsDir() {
+			href, label, size = href+"/", label+"/", ""
+		} else if readme == "" && isReadme(e.Name()) {
+			readme = filepath.Join(dir, e.Name())
+		}
+		fmt.Fprintf(&b, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td></tr>\n", href, label, size)
+	}
+	b.WriteString("</table>\n")
+
+	if readme != "" {
+		if dat, err := ioutil.ReadFile(readme); err == nil {
+			b.WriteString("<hr>\n")
+			p, _ := s.r.render(readme, dat)
+			b.WriteString(p.Body)
+		}
+	}
+	writeHTML(w, s.r.execute(&page{Title: html.EscapeString(req.URL.Path), Body: b.String()}))
+}
+
+// breadcrumbs renders the URL path as a row of links back to the root.
+func (s *server) breadcrumbs(urlPath string) string {
+	var b strings.Builder
+	href := s.prefix + "/"
+	fmt.Fprintf(&b, "<p class=\"mdview-path\"><a href=\"%s\">%s</a>", href, html.EscapeString(filepath.Base(s.root)))
+	for _, part := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		href += url.PathEscape(part) + "/"
+		fmt.Fprintf(&b, " / <a href=\"%s\">%s</a>", href, html.EscapeString(part))
+	}
+	b.WriteString("</p>\n")
+	return b.String()
+}
+
+func writeHTML(w http.ResponseWriter, page string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown", ".mdown", ".mkd", ".mkdn":
+		return true
+	}
+	return false
+}
+
+func isReadme(name string) bool {
+	for _, r := range readmeNames {
+		if name == r {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether dat looks like text rather than binary data.
+func isText(dat []byte) bool {
+	if len(dat) > 8000 {
+		dat = dat[:8000]
+	}
+	for _, c := range dat {
+		if c == 0 {
+			return false
+		}
+	}
+	// The sample may end in the middle of a multi-byte character.
+	for i := 0; i < utf8.UTFMax && len(dat) > 0 && !utf8.Valid(dat); i++ {
+		dat = dat[:len(dat)-1]
+	}
+	return utf8.Valid(dat)
+}
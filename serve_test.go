@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testServer serves a directory holding a.txt, sub/b.txt and symlinks to
+// files inside and outside of it, next to a directory that must stay
+// hidden.
+func testServer(t *testing.T) (*server, func()) {
+	dir, err := ioutil.TempDir("", "mdview-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	root, outside := filepath.Join(dir, "root"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(root, "a.txt"):         "hello\n",
+		filepath.Join(root, "sub", "b.txt"):  "inner\n",
+		filepath.Join(outside, "secret.txt"): "secret\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"in":     filepath.Join(root, "a.txt"),
+		"out":    filepath.Join(outside, "secret.txt"),
+		"outdir": outside,
+		"up":     "../outside/secret.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			os.RemoveAll(dir)
+			t.Skip("symlinks unsupported:", err)
+		}
+	}
+	return &server{root: root, r: newRenderer(false, false, false)}, func() { os.RemoveAll(dir) }
+}
+
+func TestServeConfinedToRoot(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{path: "/a.txt", code: http.StatusOK, want: "hello"},
+		{path: "/sub/b.txt", code: http.StatusOK, want: "inner"},
+		{path: "/in", code: http.StatusOK, want: "hello"},
+		{path: "/../outside/secret.txt", code: http.StatusNotFound},
+		{path: "/sub/../../outside/secret.txt", code: http.StatusNotFound},
+		{path: "/%2e%2e/outside/secret.txt", code: http.StatusNotFound},
+		{path: "/..%2foutside%2fsecret.txt", code: http.StatusNotFound},
+		{path: "/out", code: http.StatusNotFound},
+		{path: "/up", code: http.StatusNotFound},
+		{path: "/outdir/secret.txt", code: http.StatusNotFound},
+		{path: "/missing.txt", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost"+tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s: status %d, want %d", tt.path, w.Code, tt.code)
+			continue
+		}
+		if body := w.Body.String(); strings.Contains(body, "secret") || !strings.Contains(body, tt.want) {
+			t.Errorf("GET %s: body %q, want it to contain %q and no secret", tt.path, body, tt.want)
+		}
+	}
+}
+
+func TestServeDirHidesOutsideLinks(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /: status %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, name := range []string{"a.txt", "sub/", "in"} {
+		if !strings.Contains(body, ">"+name+"</a>") {
+			t.Errorf("listing lacks %s:\n%s", name, body)
+		}
+	}
+	for _, name := range []string{"out", "outdir/", "up"} {
+		if strings.Contains(body, ">"+name+"</a>") {
+			t.Errorf("listing shows %s, which leads outside the root:\n%s", name, body)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// snippets fills fenced code blocks that name a source file, such as
+//
+//	```go file=../main.go lines=102-118
+//	```
+//
+// or ```` ``` file=../main.go region=tempdir ````, among toks with those
+// lines of the file or the region between its marker comments, found
+// relative to name, the file toks were parsed from.
+// The language defaults to the one the file's extension suggests. Unless
+// confine is empty, the file must be inside that directory once symlinks
+// are followed. Blocks whose file, lines or region cannot be found keep
+// their content and are preceded by the error. It returns the files read,
+// for watching them, and the errors.
+func snippets(toks []markdown.Token, name, confine string) ([]string, []string) {
+	var deps, errs []string
+	for i, tok := range toks {
+		fence, ok := tok.(*markdown.Fence)
+		if !ok || !strings.Contains(fence.Params, "file=") {
+			continue
+		}
+		lang, opts := fenceOptions(fence.Params)
+		file := opts["file"]
+		if file == "" {
+			continue
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(name), filepath.FromSlash(file))
+		}
+		if lang == "" {
+			lang = languageOf(file)
+		}
+		deps = append(deps, file)
+		content, err := snippet(file, opts["lines"], opts["region"])
+		if err == nil && confine != "" {
+			if real, rerr := filepath.EvalSymlinks(file); rerr != nil || !within(confine, real) {
+				err = errOutsideRoot
+			}
+		}
+		if err != nil {
+			msg := fmt.Sprintf("%s:%d: %s: %v", filepath.Base(name), fence.Map[0]+1, opts["file"], err)
+			errs = append(errs, msg)
+			toks[i] = &markdown.HTMLBlock{
+				Content: `<pre class="mdview-filter-error">` + html.EscapeString(msg) + "</pre>\n" +
+					"<pre><code>" + html.EscapeString(fence.Content) + "</code></pre>\n",
+				Map: fence.Map,
+				Lvl: fence.Lvl,
+			}
+			continue
+		}
+		fence.Params = lang
+		fence.Content = content
+	}
+	return deps, errs
+}
+
+// fenceOptions splits the info string of a fence into its language, if it
+// starts with one, and key=value options, whose values may be quoted.
+func fenceOptions(params string) (string, map[string]string) {
+	var lang string
+	opts := make(map[string]string)
+	s := strings.TrimSpace(params)
+	for first := true; s != ""; first = false {
+		end := strings.IndexAny(s, " \t=")
+		if end < 0 {
+			end = len(s)
+		}
+		key := s[:end]
+		s = s[end:]
+		if !strings.HasPrefix(s, "=") {
+			if first {
+				lang = key
+			}
+			s = strings.TrimSpace(s)
+			continue
+		}
+		value, n := attributeValue(s[1:])
+		if n < 0 {
+			if n = strings.IndexAny(s[1:], " \t"); n < 0 {
+				n = len(s) - 1
+			}
+			value = s[1 : 1+n]
+		}
+		opts[key] = value
+		s = strings.TrimSpace(s[1+n:])
+	}
+	return lang, opts
+}
+
+// snippet returns the lines of file given by lines, such as "102-118",
+// "102-", "-20" or "1-3,10-12", or else those of the named region, with
+// their common indentation removed.
+func snippet(file, lines, region string) (string, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		return "", err
+	}
+	src := strings.SplitAfter(string(dat), "\n")
+	if src[len(src)-1] == "" {
+		src = src[:len(src)-1]
+	}
+
+	var out []string
+	switch {
+	case region != "":
+		if out, err = regionLines(src, region); err != nil {
+			return "", err
+		}
+	case lines != "":
+		for _, r := range strings.Split(lines, ",") {
+			from, to, err := lineRange(strings.TrimSpace(r), len(src))
+			if err != nil {
+				return "", err
+			}
+			out = append(out, src[from-1:to]...)
+		}
+	default:
+		out = src
+	}
+	for i, l := range out {
+		if !strings.HasSuffix(l, "\n") {
+			out[i] = l + "\n"
+		}
+	}
+	return dedent(out), nil
+}
+
+// lineRange parses a range of lines such as "102-118", "102-", "-20" or
+// "7" in a file of n lines.
+func lineRange(r string, n int) (int, int, error) {
+	bad := fmt.Errorf("bad line range %q", r)
+	from, to := 1, n
+	dash := strings.IndexByte(r, '-')
+	var err error
+	switch {
+	case dash < 0:
+		if from, err = strconv.Atoi(r); err != nil {
+			return 0, 0, bad
+		}
+		to = from
+	default:
+		if dash > 0 {
+			if from, err = strconv.Atoi(r[:dash]); err != nil {
+				return 0, 0, bad
+			}
+		}
+		if dash < len(r)-1 {
+			if to, err = strconv.Atoi(r[dash+1:]); err != nil {
+				return 0, 0, bad
+			}
+		}
+	}
+	if from < 1 || from > to {
+		return 0, 0, bad
+	}
+	if to > n {
+		return 0, 0, fmt.Errorf("lines %s: the file has only %d lines", r, n)
+	}
+	return from, to, nil
+}
+
+// Region markers are comments of their own, started by //, #, --, /*,
+// <!--, ;, % or ' and holding nothing but the marker.
+var (
+	regionStart = regexp.MustCompile(`^\s*(?://+|#|--|/\*+|<!--|;+|%|')\s*(?:#?region\s+|ANCHOR:\s*)([\w.-]+)\s*(?:\*+/|-->)?\s*$`)
+	regionEnd   = regexp.MustCompile(`^\s*(?://+|#|--|/\*+|<!--|;+|%|')\s*(?:#?endregion\b\s*|ANCHOR_END:\s*)([\w.-]*)\s*(?:\*+/|-->)?\s*$`)
+)
+
+// regionLines returns the lines between the comments marking the start and
+// end of the named region, which may read "region name" and
+// "endregion name", "#region name" and "#endregion", or, as in mdBook,
+// "ANCHOR: name" and "ANCHOR_END: name". The markers of other regions
+// inside it are left out; an end marker without a name closes the
+// innermost region.
+func regionLines(src []string, name string) ([]string, error) {
+	start, depth := -1, 0
+	var out []string
+	for i, l := range src {
+		if start < 0 {
+			if m := regionStart.FindStringSubmatch(l); m != nil && m[1] == name {
+				start = i
+			}
+			continue
+		}
+		if m := regionEnd.FindStringSubmatch(l); m != nil {
+			switch {
+			case m[1] == name || m[1] == "" && depth == 0:
+				return out, nil
+			case depth > 0:
+				depth--
+			}
+			continue
+		}
+		if regionStart.MatchString(l) {
+			depth++
+			continue
+		}
+		out = append(out, l)
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("no region %q", name)
+	}
+	return nil, fmt.Errorf("region %q is not closed", name)
+}
+
+// dedent joins lines, removing the indentation they have in common.
+func dedent(lines []string) string {
+	prefix := ""
+	first := true
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+		if first {
+			prefix, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	var b strings.Builder
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(l[len(prefix):])
+	}
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+func TestLineRange(t *testing.T) {
+	tests := []struct {
+		r        string
+		from, to int
+		err      string
+	}{
+		{r: "3-5", from: 3, to: 5},
+		{r: "7", from: 7, to: 7},
+		{r: "8-", from: 8, to: 10},
+		{r: "-4", from: 1, to: 4},
+		{r: "1-10", from: 1, to: 10},
+		{r: "5-3", err: `bad line range "5-3"`},
+		{r: "0-2", err: `bad line range "0-2"`},
+		{r: "a-b", err: `bad line range "a-b"`},
+		{r: "x", err: `bad line range "x"`},
+		{r: "4-11", err: "lines 4-11: the file has only 10 lines"},
+		{r: "12", err: "lines 12: the file has only 10 lines"},
+	}
+	for _, tt := range tests {
+		from, to, err := lineRange(tt.r, 10)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("lineRange(%q) error = %v, want %q", tt.r, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("lineRange(%q): %v", tt.r, err)
+		case from != tt.from || to != tt.to:
+			t.Errorf("lineRange(%q) = %d, %d, want %d, %d", tt.r, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func TestRegionLines(t *testing.T) {
+	src := strings.SplitAfter(`package main
+// region setup
+a := 1
+// region inner
+b := 2
+// endregion inner
+// endregion setup
+//#region plain
+c := 3
+//#endregion
+# ANCHOR: anchored
+d = 4
+# ANCHOR_END: anchored
+/* region nested */
+f := 6
+//#region deeper
+g := 7
+//#endregion deeper
+s := "region x"
+// region where the work happens
+h := 8
+/* #endregion */
+<!-- ANCHOR: html -->
+<p>
+<!-- ANCHOR_END: html -->
+// region open
+e := 5
+`, "\n")
+	tests := []struct {
+		name string
+		want []string
+		err  string
+	}{
+		{name: "setup", want: []string{"a := 1\n", "b := 2\n"}},
+		{name: "inner", want: []string{"b := 2\n"}},
+		{name: "plain", want: []string{"c := 3\n"}},
+		{name: "anchored", want: []string{"d = 4\n"}},
+		{name: "nested", want: []string{"f := 6\n", "g := 7\n", "s := \"region x\"\n", "// region where the work happens\n", "h := 8\n"}},
+		{name: "deeper", want: []string{"g := 7\n"}},
+		{name: "html", want: []string{"<p>\n"}},
+		{name: "where", err: `no region "where"`},
+		{name: "missing", err: `no region "missing"`},
+		{name: "open", err: `region "open" is not closed`},
+	}
+	for _, tt := range tests {
+		got, err := regionLines(src, tt.name)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("regionLines(%q) error = %v, want %q", tt.name, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("regionLines(%q): %v", tt.name, err)
+		case !reflect.DeepEqual(got, tt.want):
+			t.Errorf("regionLines(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFenceOptions(t *testing.T) {
+	tests := []struct {
+		params string
+		lang   string
+		opts   map[string]string
+	}{
+		{"go", "go", map[string]string{}},
+		{"go file=main.go lines=1-3", "go", map[string]string{"file": "main.go", "lines": "1-3"}},
+		{"file=main.go region=setup", "", map[string]string{"file": "main.go", "region": "setup"}},
+		{`sh file="my script.sh"`, "sh", map[string]string{"file": "my script.sh"}},
+		{"  py   file=a.py  ", "py", map[string]string{"file": "a.py"}},
+	}
+	for _, tt := range tests {
+		lang, opts := fenceOptions(tt.params)
+		if lang != tt.lang || !reflect.DeepEqual(opts, tt.opts) {
+			t.Errorf("fenceOptions(%q) = %q, %v, want %q, %v", tt.params, lang, opts, tt.lang, tt.opts)
+		}
+	}
+}
+
+func TestDedent(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  string
+	}{
+		{[]string{"\tif x {\n", "\t\ty()\n", "\n", "\t}\n"}, "if x {\n\ty()\n\n}\n"},
+		{[]string{"    a\n", "  b\n"}, "  a\nb\n"},
+		{[]string{"a\n", "  b\n"}, "a\n  b\n"},
+	}
+	for _, tt := range tests {
+		if got := dedent(tt.lines); got != tt.want {
+			t.Errorf("dedent(%q) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdview-snippet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {\n\t// region body\n\tprintln(1)\n\t// endregion body\n}"
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		lines, region, want, err string
+	}{
+		{lines: "1", want: "package main\n"},
+		{lines: "1,3", want: "package main\nfunc main() {\n"},
+		{lines: "6-", want: "\t// endregion body\n}\n"},
+		{region: "body", want: "println(1)\n"},
+		{lines: "9", err: "lines 9: the file has only 7 lines"},
+		{region: "nope", err: `no region "nope"`},
+	}
+	for _, tt := range tests {
+		got, err := snippet(file, tt.lines, tt.region)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("snippet(lines=%q region=%q) error = %v, want %q", tt.lines, tt.region, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("snippet(lines=%q region=%q): %v", tt.lines, tt.region, err)
+		case got != tt.want:
+			t.Errorf("snippet(lines=%q region=%q) = %q, want %q", tt.lines, tt.region, got, tt.want)
+		}
+	}
+	if _, err := snippet(filepath.Join(dir, "missing.go"), "", ""); err == nil || !os.IsNotExist(err) {
+		t.Errorf("snippet of a missing file: error = %v, want not exist", err)
+	}
+}
+
+func TestSnippetsConfined(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdview-snippet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"secret.txt": "secret\n", "root/main.go": "package main\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	src := "```go file=main.go\n```\n\n```file=../secret.txt\n```\n\n```file=link.txt\n```\n\n```file=" + filepath.ToSlash(filepath.Join(dir, "secret.txt")) + "\n```\n"
+	md := newRenderer(false, false, false).md
+	for _, confine := range []string{"", root} {
+		toks := md.Parse([]byte(src))
+		_, errs := snippets(toks, filepath.Join(root, "doc.md"), confine)
+		var quoted []string
+		for _, tok := range toks {
+			if fence, ok := tok.(*markdown.Fence); ok {
+				quoted = append(quoted, fence.Content)
+			}
+		}
+		want := []string{"package main\n", "secret\n", "secret\n", "secret\n"}
+		if confine != "" {
+			want = want[:1]
+			if len(errs) != 3 {
+				t.Errorf("errors quoting files outside %s = %q, want 3", confine, errs)
+			}
+		}
+		if !reflect.DeepEqual(quoted, want) {
+			t.Errorf("snippets confined to %q = %q, want %q", confine, quoted, want)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The preview server speaks a small HTTP protocol that lets an editor and
+// the browser follow each other:
+//
+//	GET  /_mdview/events?path=<url path>|file=<file>  server-sent events
+//	POST /_mdview/scroll                               {"file"|"path", "line", "source"}
+//	POST /_mdview/task                                 {"path", "line", "checked", "version"}
+//	POST /_mdview/fold                                 {"path", "folded"}
+//
+// Editors post their cursor line with source "editor"; every page showing
+// that document receives a "scroll" event and scrolls to the element that
+// came from that line. Pages post the line at the top of the viewport with
+// source "preview", which subscribed editors receive as a "preview" event.
+// Pages post to /task when a task list checkbox is clicked, and the server
+// ticks the task in the source file unless it changed since the page's
+// version was rendered. Pages with foldable sections post the ids of the
+// folded ones to /fold, which keeps them for the next time the document is
+// served: local storage would lose them, as it belongs to the server's
+// port, which changes from run to run. Both requests must carry an
+// X-Mdview header, which other web sites cannot add without the server's
+// consent.
+// A "reload" event is sent whenever the document, or a file it includes,
+// changes on disk, and a "navigate" event tells pages to switch to another
+// document.
+//
+// Requests must address the server as localhost or by IP address, so that
+// a web site whose name has been pointed at the loopback address cannot
+// read documents or post to the API (DNS rebinding).
+const apiPrefix = "/_mdview/"
+
+// pollInterval is how often watched documents are checked for changes.
+const pollInterval = 500 * time.Millisecond
+
+type event struct {
+	name, data string
+}
+
+// hub fans events out to everyone subscribed to a document.
+type hub struct {
+	mu       sync.Mutex
+	subs     map[string]map[chan event]bool
+	watchers map[string]bool
+	// deps holds the other files each document was last rendered from,
+	// which are watched along with it.
+	deps map[string][]string
+}
+
+func (h *hub) subscribe(name string) chan event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[string]map[chan event]bool)
+		h.watchers = make(map[string]bool)
+	}
+	if h.subs[name] == nil {
+		h.subs[name] = make(map[chan event]bool)
+	}
+	if !h.watchers[name] {
+		h.watchers[name] = true
+		go h.watch(name)
+	}
+	c := make(chan event, 16)
+	h.subs[name][c] = true
+	return c
+}
+
+// depend records the files besides name itself that name was rendered
+// from.
+func (h *hub) depend(name string, deps []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.deps == nil {
+		h.deps = make(map[string][]string)
+	}
+	h.deps[name] = deps
+}
+
+// files returns name along with the files it depends on.
+func (h *hub) files(name string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string{name}, h.deps[name]...)
+}
+
+func (h *hub) unsubscribe(name string, c chan event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[name], c)
+	if len(h.subs[name]) == 0 {
+		delete(h.subs, name)
+	}
+}
+
+func (h *hub) publish(name string, e event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.subs[name] {
+		select {
+		case c <- e:
+		default: // a slow client misses the event rather than blocking everyone
+		}
+	}
+}
+
+// broadcast sends e to every subscriber of every document and reports
+// whether anyone was listening.
+func (h *hub) broadcast(e event) bool {
+	h.mu.Lock()
+	names := make([]string, 0, len(h.subs))
+	for name := range h.subs {
+		names = append(names, name)
+	}
+	h.mu.Unlock()
+	for _, name := range names {
+		h.publish(name, e)
+	}
+	return len(names) > 0
+}
+
+// busy reports whether any document has subscribers.
+func (h *hub) busy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs) > 0
+}
+
+// watching reports whether name still has subscribers, and otherwise
+// retires its watcher.
+func (h *hub) watching(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[name] == nil {
+		delete(h.watchers, name)
+		return false
+	}
+	return true
+}
+
+// watch publishes a reload event whenever name, or a file it depends on,
+// changes on disk, for as long as anyone is subscribed to it.
+func (h *hub) watch(name string) {
+	last := make(map[string]time.Time)
+	for _, f := range h.files(name) {
+		last[f] = modTime(f)
+	}
+	for h.watching(name) {
+		time.Sleep(pollInterval)
+		changed := false
+		for _, f := range h.files(name) {
+			t := modTime(f)
+			if old, ok := last[f]; ok && !t.Equal(old) {
+				changed = true
+			}
+			last[f] = t
+		}
+		if changed {
+			h.publish(name, event{"reload", "{}"})
+		}
+	}
+}
+
+func modTime(name string) time.Time {
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// localHost reports whether the Host header host names the server by
+// address or as localhost rather than by some other host name.
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil
+}
+
+type scrollMessage struct {
+	File   string `json:"file,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Line   int    `json:"line"`
+	Source string `json:"source,omitempty"`
+}
+
+type foldMessage struct {
+	Path   string          `json:"path"`
+	Folded map[string]bool `json:"folded"`
+}
+
+type taskMessage struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Checked bool   `json:"checked"`
+	Version string `json:"version"`
+}
+
+func (s *server) serveAPI(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case apiPrefix + "events":
+		name, err := s.document(req.URL.Query().Get("file"), req.URL.Query().Get("path"))
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		s.serveEvents(w, req, name)
+	case apiPrefix + "scroll":
+		if req.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var msg scrollMessage
+		if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, err := s.document(msg.File, msg.Path)
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		e := event{name: "scroll"}
+		if msg.Source == "preview" {
+			e.name = "preview"
+		}
+		dat, _ := json.Marshal(scrollMessage{File: name, Line: msg.Line})
+		e.data = string(dat)
+		s.hub.publish(name, e)
+		w.WriteHeader(http.StatusNoContent)
+	case apiPrefix + "task":
+		if req.Method != http.MethodPost || req.Header.Get("X-Mdview") == "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var msg taskMessage
+		if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, err := s.document("", msg.Path)
+		if err != nil || !isMarkdown(name) {
+			http.NotFound(w, req)
+			return
+		}
+		if err = toggleTask(name, msg.Line, msg.Checked, msg.Version); err == errSourceChanged {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case apiPrefix + "fold":
+		if req.Method != http.MethodPost || req.Header.Get("X-Mdview") == "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var msg foldMessage
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, err := s.document("", msg.Path)
+		if err != nil || !isMarkdown(name) {
+			http.NotFound(w, req)
+			return
+		}
+		if err = saveFoldState(name, msg.Folded); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// document finds the file a protocol message refers to, either by file
+// system path (as editors know it) or by URL path (as pages know it).
+func (s *server) document(file, urlPath string) (string, error) {
+	if file == "" {
+		return s.resolve(urlPath)
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(s.root, file)
+	}
+	real, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", err
+	}
+	if !s.contains(real) {
+		return "", errOutsideRoot
+	}
+	return real, nil
+}
+
+func (s *server) serveEvents(w http.ResponseWriter, req *http.Request, name string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	c := s.hub.subscribe(name)
+	defer s.hub.unsubscribe(name, c)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case e := <-c:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// syncScript returns the script added to served markdown pages. It reloads
+// the page when the document changes, keeping the scroll position, follows
+// "navigate" events, scrolls to #line=<n> fragments, keeps the page in step
+// with an editor using the data-source-line attributes and writes clicked
+// task list checkboxes back to the source, which is at version. Unless
+// folded is empty, it also keeps the page's folded sections, which start
+// out as the JSON folded, on the server.
+func (s *server) syncScript(version, folded string) string {
+	store := ""
+	if folded != "" {
+		store = fmt.Sprintf(foldStoreScript, folded)
+	}
+	return gotoFunc + fmt.Sprintf(syncScript, s.prefix, s.prefix, store, version)
+}
+
+// foldStoreScript provides the fold script with the folded sections %s and
+// a way of saving them.
+const foldStoreScript = `window.mdviewFoldStore={folded:%s,save:function(f){
+var x=new XMLHttpRequest();x.open("POST",api+"fold");x.setRequestHeader("X-Mdview","1");
+x.send(JSON.stringify({path:path,folded:f}))}};
+`
+
+const syncScript = `<script>(function(){
+var api="%s/_mdview/",path=decodeURIComponent(location.pathname).substring(%q.length),quiet=0;
+%sfunction blocks(){return document.querySelectorAll("[data-source-line]")}
+function lineAt(el){return parseInt(el.getAttribute("data-source-line"),10)}
+function scrollToLine(line){
+var best=null,els=blocks();
+for(var i=0;i<els.length;i++){if(lineAt(els[i])<=line)best=els[i];else break}
+quiet=Date.now();
+if(best)window.scrollTo(0,best.getBoundingClientRect().top+window.pageYOffset);else window.scrollTo(0,0)}
+function topLine(){
+var els=blocks();
+for(var i=0;i<els.length;i++){if(els[i].getBoundingClientRect().bottom>0)return lineAt(els[i])}
+return 1}
+var es=new EventSource(api+"events?path="+encodeURIComponent(path));
+es.addEventListener("scroll",function(e){scrollToLine(JSON.parse(e.data).line)});
+es.addEventListener("navigate",function(e){location.href=JSON.parse(e.data).url});
+es.addEventListener("reload",function(){sessionStorage.setItem("mdview-scroll:"+path,window.pageYOffset);location.reload()});
+var y=sessionStorage.getItem("mdview-scroll:"+path);
+function hash(){if(location.hash){quiet=Date.now();mdviewGoto(decodeURIComponent(location.hash.substring(1)))}}
+if(y!==null){sessionStorage.removeItem("mdview-scroll:"+path);window.scrollTo(0,parseInt(y,10))}else window.addEventListener("load",hash);
+window.addEventListener("hashchange",hash);
+var timer=null;
+window.addEventListener("scroll",function(){
+if(Date.now()-quiet<300)return;
+clearTimeout(timer);
+timer=setTimeout(function(){
+var x=new XMLHttpRequest();x.open("POST",api+"scroll");
+x.send(JSON.stringify({path:path,line:topLine(),source:"preview"}))},100)});
+var version=%q;
+document.addEventListener("change",function(e){
+var box=e.target;if(!box.hasAttribute||!box.hasAttribute("data-task-line"))return;
+box.disabled=true;
+var x=new XMLHttpRequest();x.open("POST",api+"task");x.setRequestHeader("X-Mdview","1");
+x.onload=function(){if(x.status!==204){alert(x.responseText);location.reload()}};
+x.send(JSON.stringify({path:path,line:parseInt(box.getAttribute("data-task-line"),10),checked:box.checked,version:version}))});
+})();</script>`
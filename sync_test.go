@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost:8080", true},
+		{"LOCALHOST:8080", true},
+		{"localhost", true},
+		{"127.0.0.1:8080", true},
+		{"[::1]:8080", true},
+		{"[::1]", true},
+		{"192.168.1.20:8080", true},
+		{"evil.example:8080", false},
+		{"localhost.evil.example:8080", false},
+		{"127.0.0.1.nip.io:8080", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := localHost(tt.host); got != tt.want {
+			t.Errorf("localHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestRebindingRefused(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+	s.hub = &hub{}
+	tests := []struct {
+		method, host, path, body string
+		code                     int
+	}{
+		{"GET", "localhost:8080", "/a.txt", "", http.StatusOK},
+		{"GET", "evil.example:8080", "/a.txt", "", http.StatusForbidden},
+		{"POST", "127.0.0.1:8080", apiPrefix + "scroll", `{"path":"/a.txt","line":1}`, http.StatusNoContent},
+		{"POST", "evil.example:8080", apiPrefix + "scroll", `{"path":"/a.txt","line":1}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, "http://"+tt.host+tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s %s with Host %s: status %d, want %d", tt.method, tt.path, tt.host, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// taskSummary counts a document's task list items. Templates can print it
+// as is, e.g. "7/12 done", or use its fields.
+type taskSummary struct {
+	Done  int
+	Total int
+}
+
+func (t taskSummary) String() string {
+	return fmt.Sprintf("%d/%d done", t.Done, t.Total)
+}
+
+// taskLists renders GFM task list items, "- [ ] todo" and "- [x] done", as
+// checkboxes with the classes GitHub's stylesheet expects, and counts them.
+// Checkboxes are disabled unless interactive is set, in which case they
+// carry the source line of their item.
+func (d *document) taskLists(xhtml, interactive bool) taskSummary {
+	var sum taskSummary
+	for i, tok := range d.tokens {
+		item, ok := tok.(*markdown.ListItemOpen)
+		if !ok {
+			continue
+		}
+		inline, text, checked, ok := d.taskItem(i)
+		if !ok {
+			continue
+		}
+
+		sum.Total++
+		if checked {
+			sum.Done++
+		}
+		text.Content = text.Content[3:]
+		line := 0
+		if interactive && !d.included[item] {
+			line = item.Map[0] + 1
+		}
+		inline.Children = append([]markdown.Token{&markdown.HTMLInline{Content: checkbox(checked, xhtml, line)}}, inline.Children...)
+		d.setAttr(item, "class", "task-list-item")
+		if list := d.parentList(i); list != nil {
+			d.setAttr(list, "class", "contains-task-list")
+		}
+	}
+	return sum
+}
+
+// taskItem reports whether the list item opened at i starts with a task
+// marker, returning its first paragraph and the text token holding the
+// marker.
+func (d *document) taskItem(i int) (*markdown.Inline, *markdown.Text, bool, bool) {
+	if i+2 >= len(d.tokens) {
+		return nil, nil, false, false
+	}
+	if _, ok := d.tokens[i+1].(*markdown.ParagraphOpen); !ok {
+		return nil, nil, false, false
+	}
+	inline, ok := d.tokens[i+2].(*markdown.Inline)
+	if !ok || len(inline.Children) == 0 {
+		return nil, nil, false, false
+	}
+	text, ok := inline.Children[0].(*markdown.Text)
+	if !ok {
+		return nil, nil, false, false
+	}
+	var checked bool
+	switch {
+	case strings.HasPrefix(text.Content, "[ ]"):
+	case strings.HasPrefix(text.Content, "[x]"), strings.HasPrefix(text.Content, "[X]"):
+		checked = true
+	default:
+		return nil, nil, false, false
+	}
+	// The marker must stand on its own: "[x]" or "[x] text".
+	if rest := text.Content[3:]; rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return nil, nil, false, false
+	}
+	if len(text.Content) == 3 && len(inline.Children) > 1 {
+		if _, ok := inline.Children[1].(*markdown.Softbreak); !ok {
+			return nil, nil, false, false
+		}
+	}
+	return inline, text, checked, true
+}
+
+// parentList returns the opening token of the list containing the item
+// opened at i.
+func (d *document) parentList(i int) markdown.Token {
+	lvl := d.tokens[i].Level() - 1
+	for j := i - 1; j >= 0; j-- {
+		switch tok := d.tokens[j].(type) {
+		case *markdown.BulletListOpen, *markdown.OrderedListOpen:
+			if tok.Level() == lvl {
+				return tok
+			}
+		}
+	}
+	return nil
+}
+
+// checkbox renders a task's checkbox. Only checkboxes with a source line
+// can be ticked.
+func checkbox(checked, xhtml bool, line int) string {
+	box := `<input type="checkbox" class="task-list-item-checkbox"`
+	if line > 0 {
+		box += ` data-task-line="` + strconv.Itoa(line) + `"`
+	} else {
+		box += boolAttr("disabled", xhtml)
+	}
+	if checked {
+		box += boolAttr("checked", xhtml)
+	}
+	if xhtml {
+		return box + " />"
+	}
+	return box + ">"
+}
+
+func boolAttr(name string, xhtml bool) string {
+	if xhtml {
+		return " " + name + `="` + name + `"`
+	}
+	return " " + name
+}
+
+// errSourceChanged means the file on disk no longer matches the version of
+// it the page was rendered from.
+var errSourceChanged = errors.New("the file has changed since the page was loaded; reloading")
+
+// taskMarker matches a list item line up to and including its task marker.
+var taskMarker = regexp.MustCompile(`^(\s*(?:>\s*)*(?:[-*+]|\d+[.)])\s+\[)([ xX])\]`)
+
+// sourceVersion identifies the contents of a source file, so that a page
+// can tell whether the file has changed since it was rendered.
+func sourceVersion(dat []byte) string {
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:8])
+}
+
+// toggleTask ticks or unticks the task on the given 1-based line of the
+// markdown file name. It refuses to touch the file if it is no longer the
+// version the page showed, so edits made since are never overwritten.
+func toggleTask(name string, line int, checked bool, version string) error {
+	dat, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if sourceVersion(dat) != version {
+		return errSourceChanged
+	}
+	lines := strings.SplitAfter(string(dat), "\n")
+	if line < 1 || line > len(lines) {
+		return errSourceChanged
+	}
+	m := taskMarker.FindStringSubmatchIndex(lines[line-1])
+	if m == nil {
+		return errSourceChanged
+	}
+	mark := " "
+	if checked {
+		mark = "x"
+	}
+	l := lines[line-1]
+	lines[line-1] = l[:m[4]] + mark + l[m[5]:]
+	return writeFileAtomic(name, []byte(strings.Join(lines, "")))
+}
+
+// writeFileAtomic replaces name with dat, so that readers never see a half
+// written file.
+func writeFileAtomic(name string, dat []byte) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(dat); err == nil {
+		err = f.Chmod(info.Mode())
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
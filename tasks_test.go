@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const taskSource = "# Todo\n\n- [ ] write\n- [x] test\n  * [ ] nested\n> 1. [ ] quoted\n\ntext\n"
+
+func TestToggleTask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdview-tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "todo.md")
+	version := sourceVersion([]byte(taskSource))
+	tests := []struct {
+		line    int
+		checked bool
+		version string
+		want    string
+		err     error
+	}{
+		{line: 3, checked: true, want: "- [x] write\n"},
+		{line: 4, checked: false, want: "- [ ] test\n"},
+		{line: 5, checked: true, want: "  * [x] nested\n"},
+		{line: 6, checked: true, want: "> 1. [x] quoted\n"},
+		{line: 3, checked: true, version: "stale", err: errSourceChanged},
+		{line: 8, checked: true, err: errSourceChanged},
+		{line: 0, checked: true, err: errSourceChanged},
+		{line: 20, checked: true, err: errSourceChanged},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(file, []byte(taskSource), 0644); err != nil {
+			t.Fatal(err)
+		}
+		v := version
+		if tt.version != "" {
+			v = tt.version
+		}
+		err := toggleTask(file, tt.line, tt.checked, v)
+		dat, _ := ioutil.ReadFile(file)
+		lines := strings.SplitAfter(string(dat), "\n")
+		switch {
+		case err != tt.err:
+			t.Errorf("toggleTask(line %d, %v) error = %v, want %v", tt.line, tt.checked, err, tt.err)
+		case err != nil && string(dat) != taskSource:
+			t.Errorf("toggleTask(line %d) failed but changed the file to %q", tt.line, dat)
+		case err == nil && lines[tt.line-1] != tt.want:
+			t.Errorf("toggleTask(line %d, %v) wrote %q, want %q", tt.line, tt.checked, lines[tt.line-1], tt.want)
+		case err == nil && strings.Count(string(dat), "\n") != strings.Count(taskSource, "\n"):
+			t.Errorf("toggleTask(line %d) changed other lines: %q", tt.line, dat)
+		}
+	}
+}
+
+func TestTaskEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdview-tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "todo.md")
+	s := &server{root: dir, r: newRenderer(false, false, false), hub: &hub{}}
+	version := sourceVersion([]byte(taskSource))
+	tests := []struct {
+		method, header, body string
+		code                 int
+		changed              bool
+	}{
+		{method: "POST", header: "1", body: `{"path":"/todo.md","line":3,"checked":true,"version":"` + version + `"}`, code: http.StatusNoContent, changed: true},
+		{method: "POST", body: `{"path":"/todo.md","line":3,"checked":true,"version":"` + version + `"}`, code: http.StatusForbidden},
+		{method: "GET", header: "1", code: http.StatusForbidden},
+		{method: "POST", header: "1", body: `{"path":"/todo.md","line":3,"checked":true,"version":"stale"}`, code: http.StatusConflict},
+		{method: "POST", header: "1", body: `{"path":"/../todo.md","line":3,"checked":true,"version":"` + version + `"}`, code: http.StatusNoContent, changed: true},
+		{method: "POST", header: "1", body: `{"path":"/missing.md","line":3,"checked":true,"version":"` + version + `"}`, code: http.StatusNotFound},
+		{method: "POST", header: "1", body: `{`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(file, []byte(taskSource), 0644); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(tt.method, "http://localhost"+apiPrefix+"task", strings.NewReader(tt.body))
+		if tt.header != "" {
+			req.Header.Set("X-Mdview", tt.header)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		dat, _ := ioutil.ReadFile(file)
+		if w.Code != tt.code || (string(dat) != taskSource) != tt.changed {
+			t.Errorf("%s %s: status %d, changed %v, want %d, %v", tt.method, tt.body, w.Code, string(dat) != taskSource, tt.code, tt.changed)
+		}
+	}
+}
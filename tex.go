@@ -0,0 +1,807 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// texToMathML converts the TeX math in src, which covers the commands
+// commonly used in technical writing, to a MathML <math> element. Input
+// it cannot convert produces an <merror> showing the source, with the
+// reason in its title.
+func texToMathML(src string, display bool) (out string) {
+	open := `<math xmlns="http://www.w3.org/1998/Math/MathML"`
+	if display {
+		open += ` display="block"`
+	}
+	open += ">"
+	defer func() {
+		if e := recover(); e != nil {
+			msg, ok := e.(texError)
+			if !ok {
+				panic(e)
+			}
+			out = open + `<merror title="` + html.EscapeString(string(msg)) + `"><mtext>` +
+				html.EscapeString(src) + "</mtext></merror></math>"
+		}
+	}()
+	p := &texParser{src: src, display: display}
+	return open + "<semantics>" + p.parse() +
+		`<annotation encoding="application/x-tex">` + html.EscapeString(src) + "</annotation></semantics></math>"
+}
+
+// texError is raised, as a panic, for input the parser cannot handle.
+type texError string
+
+type texParser struct {
+	src     string
+	pos     int
+	display bool
+}
+
+// atomKind tells how an atom takes sub- and superscripts.
+type atomKind int
+
+const (
+	ordinary      atomKind = iota
+	bigOperator            // limits under and over it in display math
+	function               // followed by an invisible function application
+	limitFunction          // both, like \lim
+)
+
+func (p *texParser) fail(format string, args ...interface{}) {
+	panic(texError(fmt.Sprintf(format, args...)))
+}
+
+// parse converts the whole source, which must be balanced.
+func (p *texParser) parse() string {
+	nodes := p.seq()
+	if p.pos < len(p.src) {
+		switch {
+		case p.src[p.pos] == '}':
+			p.fail("unbalanced }")
+		case p.atCommand("right"):
+			p.fail(`\right without \left`)
+		case p.atCommand("end"):
+			p.fail(`\end without \begin`)
+		default:
+			p.fail("%s outside of an environment", p.src[p.pos:p.pos+1])
+		}
+	}
+	return row(nodes)
+}
+
+// sub parses src, a part of the source such as an optional argument, on
+// its own.
+func (p *texParser) sub(src string) string {
+	q := &texParser{src: src, display: p.display}
+	return q.parse()
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// atCommand reports whether the next token is \name.
+func (p *texParser) atCommand(name string) bool {
+	rest := p.src[p.pos:]
+	if !strings.HasPrefix(rest, `\`+name) {
+		return false
+	}
+	rest = rest[len(name)+1:]
+	return rest == "" || !isASCIILetter(rest[0])
+}
+
+// seq parses atoms up to the end of the current group, cell or row.
+func (p *texParser) seq() []string {
+	var nodes []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nodes
+		}
+		if c := p.src[p.pos]; c == '}' || c == '&' {
+			return nodes
+		}
+		if strings.HasPrefix(p.src[p.pos:], `\\`) || p.atCommand("end") || p.atCommand("right") {
+			return nodes
+		}
+		nodes = append(nodes, p.scripted())
+	}
+}
+
+// scripted parses an atom together with its sub- and superscripts.
+func (p *texParser) scripted() string {
+	base, kind := p.atom()
+	limits := p.display && (kind == bigOperator || kind == limitFunction)
+	var sub, sup string
+scripts:
+	for {
+		p.skipSpace()
+		switch {
+		case p.pos >= len(p.src):
+			break scripts
+		case p.atCommand("limits"):
+			p.pos += len(`\limits`)
+			limits = true
+		case p.atCommand("nolimits"):
+			p.pos += len(`\nolimits`)
+			limits = false
+		case p.src[p.pos] == '_':
+			if sub != "" {
+				p.fail("double subscript")
+			}
+			p.pos++
+			sub = p.arg()
+		case p.src[p.pos] == '^':
+			if sup != "" {
+				p.fail("double superscript")
+			}
+			p.pos++
+			sup = p.arg()
+		case p.src[p.pos] == '\'':
+			if sup != "" {
+				p.fail("double superscript")
+			}
+			n := 0
+			for p.pos < len(p.src) && p.src[p.pos] == '\'' {
+				p.pos++
+				n++
+			}
+			sup = mo(strings.Repeat("′", n))
+		default:
+			break scripts
+		}
+	}
+
+	out := base
+	switch {
+	case sub != "" && sup != "" && limits:
+		out = "<munderover>" + base + sub + sup + "</munderover>"
+	case sub != "" && sup != "":
+		out = "<msubsup>" + base + sub + sup + "</msubsup>"
+	case sub != "" && limits:
+		out = "<munder>" + base + sub + "</munder>"
+	case sub != "":
+		out = "<msub>" + base + sub + "</msub>"
+	case sup != "" && limits:
+		out = "<mover>" + base + sup + "</mover>"
+	case sup != "":
+		out = "<msup>" + base + sup + "</msup>"
+	}
+	if kind == function || kind == limitFunction {
+		out += "<mo>&#x2061;</mo>"
+	}
+	return out
+}
+
+// arg parses a command argument or script: a group or a single token.
+func (p *texParser) arg() string {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		p.fail("missing argument")
+	}
+	if c := p.src[p.pos]; c >= '0' && c <= '9' {
+		p.pos++
+		return mn(string(c))
+	}
+	s, _ := p.atom()
+	return s
+}
+
+// rawArg returns the source of a braced argument, or of a single
+// character.
+func (p *texParser) rawArg() string {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		p.fail("missing argument")
+	}
+	if p.src[p.pos] != '{' {
+		_, n := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += n
+		return p.src[p.pos-n : p.pos]
+	}
+	depth := 0
+	for i := p.pos; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				s := p.src[p.pos+1 : i]
+				p.pos = i + 1
+				return s
+			}
+		}
+	}
+	p.fail("missing }")
+	return ""
+}
+
+// optArg returns the source of an optional [argument], if there is one.
+func (p *texParser) optArg() (string, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '[' {
+		return "", false
+	}
+	depth := 0
+	for i := p.pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ']':
+			if depth == 0 {
+				s := p.src[p.pos+1 : i]
+				p.pos = i + 1
+				return s, true
+			}
+		}
+	}
+	p.fail("missing ]")
+	return "", false
+}
+
+func (p *texParser) expect(c byte) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != c {
+		p.fail("missing %c", c)
+	}
+	p.pos++
+}
+
+// atom parses a single element: a group, a command or a character.
+func (p *texParser) atom() (string, atomKind) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		p.fail("missing argument")
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '{':
+		p.pos++
+		nodes := p.seq()
+		p.expect('}')
+		return row(nodes), ordinary
+	case c == '\\':
+		return p.command()
+	case c >= '0' && c <= '9' || c == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1]):
+		start := p.pos
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) ||
+			p.src[p.pos] == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		return mn(p.src[start:p.pos]), ordinary
+	case isASCIILetter(c):
+		p.pos++
+		return mi(string(c)), ordinary
+	case c == '^' || c == '_':
+		// A script with nothing to attach to, as in {}^{14}C.
+		return "<mrow></mrow>", ordinary
+	case c == '}':
+		p.fail("unbalanced }")
+	case c == '&':
+		p.fail("& outside of an environment")
+	case c == '#' || c == '$':
+		p.fail("unexpected %c", c)
+	case c == '~':
+		p.pos++
+		return `<mspace width="0.333em"/>`, ordinary
+	}
+	r, n := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += n
+	if s, ok := texChars[r]; ok {
+		return s, ordinary
+	}
+	if unicode.IsLetter(r) {
+		return mi(string(r)), ordinary
+	}
+	return mo(string(r)), ordinary
+}
+
+// command parses a control sequence and its arguments.
+func (p *texParser) command() (string, atomKind) {
+	p.pos++
+	if p.pos >= len(p.src) {
+		p.fail(`trailing \`)
+	}
+	if c := p.src[p.pos]; !isASCIILetter(c) {
+		p.pos++
+		if s, ok := texSymbols[string(c)]; ok {
+			return s, ordinary
+		}
+		p.fail(`unknown command \%c`, c)
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isASCIILetter(p.src[p.pos]) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+
+	if s, ok := texSymbols[name]; ok {
+		return s, ordinary
+	}
+	if s, ok := texBigOperators[name]; ok {
+		if strings.Contains(name, "int") {
+			return mo(s), ordinary // integrals keep their limits at the side
+		}
+		return `<mo movablelimits="true">` + html.EscapeString(s) + "</mo>", bigOperator
+	}
+	if texFunctions[name] {
+		return mi(name), function
+	}
+	if texLimitFunctions[name] {
+		return `<mo movablelimits="true" form="prefix">` + name + "</mo>", limitFunction
+	}
+	if s, ok := texSpaces[name]; ok {
+		return `<mspace width="` + s + `"/>`, ordinary
+	}
+	if texIgnored[name] {
+		return "", ordinary
+	}
+	if accent, ok := texAccents[name]; ok {
+		base := p.arg()
+		if name == "underline" || name == "underbrace" {
+			return `<munder accentunder="true">` + base + `<mo stretchy="true">` + accent + "</mo></munder>", bigOperator
+		}
+		if name == "overbrace" {
+			return `<mover accent="true">` + base + `<mo stretchy="true">` + accent + "</mo></mover>", bigOperator
+		}
+		stretchy := "false"
+		if strings.HasPrefix(name, "wide") || name == "overline" || name == "overrightarrow" || name == "overleftarrow" {
+			stretchy = "true"
+		}
+		return `<mover accent="true">` + base + `<mo stretchy="` + stretchy + `">` + accent + "</mo></mover>", ordinary
+	}
+	if variant, ok := texVariants[name]; ok {
+		return p.variant(variant), ordinary
+	}
+	if size, ok := texDelimiterSizes[name]; ok {
+		return `<mo minsize="` + size + `" maxsize="` + size + `">` + html.EscapeString(p.delimiter()) + "</mo>", ordinary
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num := p.arg()
+		den := p.arg()
+		frac := "<mfrac>" + num + den + "</mfrac>"
+		switch name {
+		case "dfrac", "cfrac":
+			frac = `<mstyle displaystyle="true" scriptlevel="0">` + frac + "</mstyle>"
+		case "tfrac":
+			frac = `<mstyle displaystyle="false">` + frac + "</mstyle>"
+		}
+		return frac, ordinary
+	case "binom", "dbinom", "tbinom":
+		top := p.arg()
+		bottom := p.arg()
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + top + bottom + "</mfrac><mo>)</mo></mrow>", ordinary
+	case "sqrt":
+		if index, ok := p.optArg(); ok {
+			base := p.arg()
+			return "<mroot>" + base + p.sub(index) + "</mroot>", ordinary
+		}
+		return "<msqrt>" + p.arg() + "</msqrt>", ordinary
+	case "text", "textrm", "textnormal", "textup", "mbox", "hbox":
+		return mtext(p.rawArg()), ordinary
+	case "textit", "emph":
+		return `<mtext mathvariant="italic">` + html.EscapeString(p.rawArg()) + "</mtext>", ordinary
+	case "textbf":
+		return `<mtext mathvariant="bold">` + html.EscapeString(p.rawArg()) + "</mtext>", ordinary
+	case "texttt":
+		return `<mtext mathvariant="monospace">` + html.EscapeString(p.rawArg()) + "</mtext>", ordinary
+	case "operatorname":
+		return mi(strings.TrimSpace(p.rawArg())), function
+	case "left":
+		open := p.delimiter()
+		nodes := p.seq()
+		if !p.atCommand("right") {
+			p.fail(`\left without \right`)
+		}
+		p.pos += len(`\right`)
+		close := p.delimiter()
+		return "<mrow>" + fence(open) + row(nodes) + fence(close) + "</mrow>", ordinary
+	case "middle":
+		return fence(p.delimiter()), ordinary
+	case "not":
+		p.skipSpace()
+		next, _ := p.atom()
+		if !strings.HasPrefix(next, "<mo>") {
+			p.fail(`\not must precede a relation`)
+		}
+		return strings.TrimSuffix(next, "</mo>") + "̸</mo>", ordinary
+	case "overset", "stackrel":
+		over := p.arg()
+		return "<mover>" + p.arg() + over + "</mover>", ordinary
+	case "underset":
+		under := p.arg()
+		return "<munder>" + p.arg() + under + "</munder>", ordinary
+	case "pmod":
+		return `<mspace width="1em"/><mo>(</mo><mi>mod</mi><mspace width="0.333em"/>` + p.arg() + "<mo>)</mo>", ordinary
+	case "bmod", "mod":
+		return `<mo lspace="0.222em" rspace="0.222em">mod</mo>`, ordinary
+	case "label", "tag":
+		p.rawArg()
+		return "", ordinary
+	case "begin":
+		return p.environment(strings.TrimSpace(p.rawArg())), ordinary
+	}
+	p.fail(`unknown command \%s`, name)
+	return "", ordinary
+}
+
+// delimiter parses the delimiter after \left, \right, \big and the like.
+func (p *texParser) delimiter() string {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		p.fail("missing delimiter")
+	}
+	if p.src[p.pos] == '\\' {
+		s, _ := p.command()
+		if !strings.HasPrefix(s, "<mo") {
+			p.fail("missing delimiter")
+		}
+		return html.UnescapeString(s[strings.IndexByte(s, '>')+1 : strings.LastIndexByte(s, '<')])
+	}
+	r, n := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += n
+	if !strings.ContainsRune("()[]|/.<>", r) {
+		p.fail("bad delimiter %c", r)
+	}
+	switch r {
+	case '.':
+		return ""
+	case '<':
+		return "⟨"
+	case '>':
+		return "⟩"
+	}
+	return string(r)
+}
+
+func fence(delim string) string {
+	if delim == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true" symmetric="true">` + html.EscapeString(delim) + "</mo>"
+}
+
+// environment parses the body of \begin{name} up to its \end.
+func (p *texParser) environment(name string) string {
+	var open, close, align string
+	attrs := ""
+	switch name {
+	case "matrix", "smallmatrix":
+	case "pmatrix":
+		open, close = "(", ")"
+	case "bmatrix":
+		open, close = "[", "]"
+	case "Bmatrix":
+		open, close = "{", "}"
+	case "vmatrix":
+		open, close = "|", "|"
+	case "Vmatrix":
+		open, close = "‖", "‖"
+	case "cases":
+		open, align = "{", "left left"
+	case "aligned", "align", "align*", "split", "eqnarray", "eqnarray*", "alignat", "alignat*":
+		align = "right left right left right left"
+		attrs = ` displaystyle="true"`
+	case "gathered", "gather", "gather*":
+		attrs = ` displaystyle="true"`
+	case "array":
+		spec := p.rawArg()
+		for _, c := range spec {
+			switch c {
+			case 'l':
+				align += "left "
+			case 'c':
+				align += "center "
+			case 'r':
+				align += "right "
+			}
+		}
+	default:
+		p.fail("unknown environment %s", name)
+	}
+	if strings.HasPrefix(name, "alignat") {
+		p.rawArg()
+	}
+
+	var rows [][]string
+	cells := []string{}
+	for {
+		cells = append(cells, row(p.seq()))
+		if p.pos >= len(p.src) {
+			p.fail(`\begin{%s} without \end`, name)
+		}
+		switch {
+		case p.src[p.pos] == '&':
+			p.pos++
+			continue
+		case strings.HasPrefix(p.src[p.pos:], `\\`):
+			p.pos += 2
+			p.optArg() // row spacing
+			rows = append(rows, cells)
+			cells = []string{}
+			continue
+		case p.atCommand("end"):
+			p.pos += len(`\end`)
+			if end := strings.TrimSpace(p.rawArg()); end != name {
+				p.fail(`\begin{%s} ended by \end{%s}`, name, end)
+			}
+		case p.src[p.pos] == '}':
+			p.fail("unbalanced }")
+		default:
+			p.fail(`\right without \left`)
+		}
+		break
+	}
+	if len(cells) > 1 || cells[0] != "<mrow></mrow>" {
+		rows = append(rows, cells)
+	}
+
+	var b strings.Builder
+	b.WriteString("<mtable" + attrs)
+	if align != "" {
+		b.WriteString(` columnalign="` + strings.TrimSpace(align) + `"`)
+	}
+	b.WriteString(">")
+	for _, cells := range rows {
+		b.WriteString("<mtr>")
+		for _, c := range cells {
+			b.WriteString("<mtd>" + c + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	if open == "" && close == "" {
+		return b.String()
+	}
+	return "<mrow>" + fence(open) + b.String() + fence(close) + "</mrow>"
+}
+
+// variant renders the argument of a font command such as \mathbb. Plain
+// letters and digits are mapped onto the Unicode mathematical alphabets;
+// anything else is rendered as is.
+func (p *texParser) variant(v texVariant) string {
+	src := p.rawArg()
+	plain := src != ""
+	for i := 0; i < len(src); i++ {
+		if !isASCIILetter(src[i]) && !isDigit(src[i]) && src[i] != ' ' {
+			plain = false
+		}
+	}
+	if !plain {
+		if v.name == "normal" {
+			return `<mstyle mathvariant="normal">` + p.sub(src) + "</mstyle>"
+		}
+		return p.sub(src)
+	}
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == ' ':
+		case isDigit(c):
+			b.WriteString(mn(v.char(c)))
+		case v.name == "normal":
+			b.WriteString(`<mi mathvariant="normal">` + string(c) + "</mi>")
+		default:
+			b.WriteString(mi(v.char(c)))
+		}
+	}
+	return "<mrow>" + b.String() + "</mrow>"
+}
+
+// texVariant is one of the Unicode mathematical alphabets: the code points
+// of its A, a and 0, and the letters encoded elsewhere.
+type texVariant struct {
+	name               string
+	upper, lower, zero rune
+	exceptions         map[byte]rune
+}
+
+func (v texVariant) char(c byte) string {
+	if r, ok := v.exceptions[c]; ok {
+		return string(r)
+	}
+	switch {
+	case c >= 'A' && c <= 'Z' && v.upper != 0:
+		return string(v.upper + rune(c-'A'))
+	case c >= 'a' && c <= 'z' && v.lower != 0:
+		return string(v.lower + rune(c-'a'))
+	case c >= '0' && c <= '9' && v.zero != 0:
+		return string(v.zero + rune(c-'0'))
+	}
+	return string(c)
+}
+
+var texVariants = map[string]texVariant{
+	"mathrm":     {name: "normal"},
+	"mathit":     {name: "italic"},
+	"mathbf":     {name: "bold", upper: 0x1D400, lower: 0x1D41A, zero: 0x1D7CE},
+	"boldsymbol": {name: "bold", upper: 0x1D400, lower: 0x1D41A, zero: 0x1D7CE},
+	"bm":         {name: "bold", upper: 0x1D400, lower: 0x1D41A, zero: 0x1D7CE},
+	"mathsf":     {name: "sans-serif", upper: 0x1D5A0, lower: 0x1D5BA, zero: 0x1D7E2},
+	"mathtt":     {name: "monospace", upper: 0x1D670, lower: 0x1D68A, zero: 0x1D7F6},
+	"mathbb": {name: "double-struck", upper: 0x1D538, lower: 0x1D552, zero: 0x1D7D8,
+		exceptions: map[byte]rune{'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'}},
+	"mathcal": {name: "script", upper: 0x1D49C, lower: 0x1D4B6,
+		exceptions: map[byte]rune{'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'}},
+	"mathscr": {name: "script", upper: 0x1D49C, lower: 0x1D4B6,
+		exceptions: map[byte]rune{'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'}},
+	"mathfrak": {name: "fraktur", upper: 0x1D504, lower: 0x1D51E,
+		exceptions: map[byte]rune{'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'}},
+}
+
+func isASCIILetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func mi(s string) string    { return "<mi>" + html.EscapeString(s) + "</mi>" }
+func mn(s string) string    { return "<mn>" + html.EscapeString(s) + "</mn>" }
+func mo(s string) string    { return "<mo>" + html.EscapeString(s) + "</mo>" }
+func mtext(s string) string { return "<mtext>" + html.EscapeString(s) + "</mtext>" }
+
+// row wraps nodes in an <mrow> unless there is exactly one.
+func row(nodes []string) string {
+	var b strings.Builder
+	n := 0
+	for _, node := range nodes {
+		if node != "" {
+			b.WriteString(node)
+			n++
+		}
+	}
+	if n == 1 {
+		return b.String()
+	}
+	return "<mrow>" + b.String() + "</mrow>"
+}
+
+// texChars are plain characters that TeX renders differently from their
+// MathML defaults.
+var texChars = map[rune]string{
+	'-':  mo("−"),
+	'*':  mo("∗"),
+	'(':  `<mo stretchy="false">(</mo>`,
+	')':  `<mo stretchy="false">)</mo>`,
+	'[':  `<mo stretchy="false">[</mo>`,
+	']':  `<mo stretchy="false">]</mo>`,
+	'|':  `<mo stretchy="false">|</mo>`,
+	'<':  mo("<"),
+	'>':  mo(">"),
+	'\'': mo("′"),
+}
+
+// texSymbols are the commands that stand for a single identifier or
+// operator.
+var texSymbols = map[string]string{}
+
+func init() {
+	for name, s := range map[string]string{
+		"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+		"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+		"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ",
+		"rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+		"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+		"infty": "∞", "ell": "ℓ", "hbar": "ℏ", "imath": "ı", "jmath": "ȷ", "emptyset": "∅",
+		"varnothing": "∅", "aleph": "ℵ", "beth": "ℶ", "Re": "ℜ", "Im": "ℑ", "wp": "℘",
+		"partial": "∂", "nabla": "∇", "angle": "∠", "triangle": "△", "top": "⊤", "bot": "⊥",
+		"Box": "□", "Diamond": "◇", "clubsuit": "♣", "diamondsuit": "♢", "heartsuit": "♡",
+		"spadesuit": "♠", "flat": "♭", "natural": "♮", "sharp": "♯", "checkmark": "✓",
+		"%": "%", "$": "$", "#": "#", "&": "&", "_": "_",
+	} {
+		texSymbols[name] = mi(s)
+	}
+	for name, s := range map[string]string{
+		"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+		"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	} {
+		texSymbols[name] = `<mi mathvariant="normal">` + s + "</mi>"
+	}
+	for name, s := range map[string]string{
+		"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+		"circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "oslash": "⊘",
+		"odot": "⊙", "cap": "∩", "cup": "∪", "sqcap": "⊓", "sqcup": "⊔", "uplus": "⊎",
+		"wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨", "setminus": "∖", "smallsetminus": "∖",
+		"wr": "≀", "dagger": "†", "ddagger": "‡", "amalg": "⨿",
+		"le": "≤", "leq": "≤", "ge": "≥", "geq": "≥", "leqslant": "⩽", "geqslant": "⩾",
+		"neq": "≠", "ne": "≠", "approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃",
+		"cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫", "prec": "≺", "succ": "≻",
+		"preceq": "⪯", "succeq": "⪰", "subset": "⊂", "supset": "⊃", "subseteq": "⊆",
+		"supseteq": "⊇", "subsetneq": "⊊", "supsetneq": "⊋", "sqsubseteq": "⊑", "sqsupseteq": "⊒",
+		"in": "∈", "notin": "∉", "ni": "∋", "owns": "∋", "mid": "∣", "nmid": "∤",
+		"parallel": "∥", "nparallel": "∦", "perp": "⊥", "models": "⊨", "vdash": "⊢",
+		"dashv": "⊣", "asymp": "≍", "doteq": "≐", "triangleq": "≜", "coloneqq": "≔",
+		"lt": "<", "gt": ">",
+		"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+		"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹",
+		"impliedby": "⟸", "iff": "⟺", "mapsto": "↦", "longmapsto": "⟼", "uparrow": "↑",
+		"downarrow": "↓", "updownarrow": "↕", "Uparrow": "⇑", "Downarrow": "⇓",
+		"longrightarrow": "⟶", "longleftarrow": "⟵", "longleftrightarrow": "⟷",
+		"Longrightarrow": "⟹", "Longleftarrow": "⟸", "Longleftrightarrow": "⟺",
+		"hookrightarrow": "↪", "hookleftarrow": "↩", "nearrow": "↗", "searrow": "↘",
+		"swarrow": "↙", "nwarrow": "↖", "rightharpoonup": "⇀", "leftharpoonup": "↼",
+		"rightleftharpoons": "⇌", "leadsto": "⇝",
+		"forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬",
+		"therefore": "∴", "because": "∵",
+		"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+		"langle": "⟨", "rangle": "⟩", "lceil": "⌈", "rceil": "⌉", "lfloor": "⌊", "rfloor": "⌋",
+		"lvert": "|", "rvert": "|", "vert": "|", "lVert": "‖", "rVert": "‖", "Vert": "‖",
+		"backslash": "\\", "colon": ":", "prime": "′",
+		"{": "{", "}": "}", "|": "‖",
+	} {
+		texSymbols[name] = mo(s)
+	}
+	texSymbols[","] = `<mspace width="0.167em"/>`
+	texSymbols[":"] = `<mspace width="0.222em"/>`
+	texSymbols[">"] = `<mspace width="0.222em"/>`
+	texSymbols[";"] = `<mspace width="0.278em"/>`
+	texSymbols["!"] = `<mspace width="-0.167em"/>`
+	texSymbols[" "] = `<mspace width="0.333em"/>`
+}
+
+var texBigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+	"bigcup": "⋃", "bigcap": "⋂", "bigsqcup": "⨆", "bigoplus": "⨁", "bigotimes": "⨂",
+	"bigodot": "⨀", "biguplus": "⨄", "bigvee": "⋁", "bigwedge": "⋀",
+}
+
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true,
+	"coth": true, "log": true, "ln": true, "lg": true, "exp": true, "arg": true, "deg": true,
+	"dim": true, "hom": true, "ker": true,
+}
+
+var texLimitFunctions = map[string]bool{
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true,
+	"inf": true, "det": true, "gcd": true, "Pr": true, "argmax": true, "argmin": true,
+}
+
+var texSpaces = map[string]string{
+	"quad": "1em", "qquad": "2em", "enspace": "0.5em", "thinspace": "0.167em",
+	"medspace": "0.222em", "thickspace": "0.278em", "negthinspace": "-0.167em",
+}
+
+// texIgnored are commands that only tweak TeX's layout.
+var texIgnored = map[string]bool{
+	"displaystyle": true, "textstyle": true, "scriptstyle": true, "scriptscriptstyle": true,
+	"nonumber": true, "notag": true, "hline": true, "limits": true, "nolimits": true,
+	"mathstrut": true, "strut": true,
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "check": "ˇ", "tilde": "~", "widetilde": "~", "acute": "´",
+	"grave": "`", "dot": "˙", "ddot": "¨", "breve": "˘", "bar": "‾", "vec": "→",
+	"overline": "‾", "underline": "_", "overrightarrow": "→", "overleftarrow": "←",
+	"overbrace": "⏞", "underbrace": "⏟",
+}
+
+var texDelimiterSizes = map[string]string{
+	"big": "1.2em", "bigl": "1.2em", "bigr": "1.2em", "bigm": "1.2em",
+	"Big": "1.8em", "Bigl": "1.8em", "Bigr": "1.8em", "Bigm": "1.8em",
+	"bigg": "2.4em", "biggl": "2.4em", "biggr": "2.4em", "biggm": "2.4em",
+	"Bigg": "3em", "Biggl": "3em", "Biggr": "3em", "Biggm": "3em",
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// mathBody returns the MathML texToMathML wraps in <semantics>, or the
+// whole output when it is an error.
+func mathBody(out string) string {
+	const open = `<math xmlns="http://www.w3.org/1998/Math/MathML"><semantics>`
+	if !strings.HasPrefix(out, open) {
+		return out
+	}
+	out = out[len(open):]
+	return out[:strings.Index(out, "<annotation")]
+}
+
+func TestTeXToMathML(t *testing.T) {
+	tests := []struct {
+		tex, want string
+	}{
+		{`x^2`, `<msup><mi>x</mi><mn>2</mn></msup>`},
+		{`x_i^2`, `<msubsup><mi>x</mi><mi>i</mi><mn>2</mn></msubsup>`},
+		{`12.5`, `<mn>12.5</mn>`},
+		{`a<b`, `<mrow><mi>a</mi><mo>&lt;</mo><mi>b</mi></mrow>`},
+		{`\alpha + \beta`, `<mrow><mi>α</mi><mo>+</mo><mi>β</mi></mrow>`},
+		{`\frac{a}{b}`, `<mfrac><mi>a</mi><mi>b</mi></mfrac>`},
+		{`\binom{n}{k}`, `<mrow><mo>(</mo><mfrac linethickness="0"><mi>n</mi><mi>k</mi></mfrac><mo>)</mo></mrow>`},
+		{`\sqrt{x}`, `<msqrt><mi>x</mi></msqrt>`},
+		{`\sqrt[3]{x}`, `<mroot><mi>x</mi><mn>3</mn></mroot>`},
+		{`\sum_{i=1}^n i`, `<mrow><msubsup><mo movablelimits="true">∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></msubsup><mi>i</mi></mrow>`},
+		{`\int_0^1 f`, `<mrow><msubsup><mo>∫</mo><mn>0</mn><mn>1</mn></msubsup><mi>f</mi></mrow>`},
+		{`\lim_{x\to0} f`, `<mrow><msub><mo movablelimits="true" form="prefix">lim</mo><mrow><mi>x</mi><mo>→</mo><mn>0</mn></mrow></msub><mo>&#x2061;</mo><mi>f</mi></mrow>`},
+		{`\sin x`, `<mrow><mi>sin</mi><mo>&#x2061;</mo><mi>x</mi></mrow>`},
+		{`\operatorname{rank} A`, `<mrow><mi>rank</mi><mo>&#x2061;</mo><mi>A</mi></mrow>`},
+		{`\left( x \right)`, `<mrow><mo fence="true" stretchy="true" symmetric="true">(</mo><mi>x</mi><mo fence="true" stretchy="true" symmetric="true">)</mo></mrow>`},
+		{`\mathbb{R}`, `<mrow><mi>ℝ</mi></mrow>`},
+		{`\text{if } x`, `<mrow><mtext>if </mtext><mi>x</mi></mrow>`},
+		{`\hat{x}`, `<mover accent="true"><mi>x</mi><mo stretchy="false">^</mo></mover>`},
+		{`\not=`, "<mo>=\u0338</mo>"},
+		{`\begin{pmatrix} a & b \\ c & d \end{pmatrix}`, `<mrow><mo fence="true" stretchy="true" symmetric="true">(</mo><mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable><mo fence="true" stretchy="true" symmetric="true">)</mo></mrow>`},
+		{`\begin{cases} 1 & x>0 \\ 0 & \text{else} \end{cases}`, `<mrow><mo fence="true" stretchy="true" symmetric="true">{</mo><mtable columnalign="left left"><mtr><mtd><mn>1</mn></mtd><mtd><mrow><mi>x</mi><mo>&gt;</mo><mn>0</mn></mrow></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd><mtext>else</mtext></mtd></mtr></mtable></mrow>`},
+	}
+	for _, tt := range tests {
+		if got := mathBody(texToMathML(tt.tex, false)); got != tt.want {
+			t.Errorf("texToMathML(%q):\n got %s\nwant %s", tt.tex, got, tt.want)
+		}
+	}
+}
+
+func TestTeXToMathMLDisplay(t *testing.T) {
+	want := `<math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mi>x</mi><annotation encoding="application/x-tex">x</annotation></semantics></math>`
+	if got := texToMathML("x", true); got != want {
+		t.Errorf("texToMathML(%q, true) = %s, want %s", "x", got, want)
+	}
+}
+
+func TestTeXToMathMLErrors(t *testing.T) {
+	tests := []struct {
+		tex, reason string
+	}{
+		{`\frac{a}`, "missing argument"},
+		{`x^`, "missing argument"},
+		{`}`, "unbalanced }"},
+		{`{x`, "missing }"},
+		{`\foo`, `unknown command \foo`},
+		{`\`, `trailing \`},
+		{`\left( x`, `\left without \right`},
+		{`\right)`, `\right without \left`},
+		{`\end{x}`, `\end without \begin`},
+		{`&`, "&amp; outside of an environment"},
+		{`\begin{foo} \end{foo}`, "unknown environment foo"},
+		{`\begin{matrix} a \end{pmatrix}`, `\begin{matrix} ended by \end{pmatrix}`},
+	}
+	for _, tt := range tests {
+		got := texToMathML(tt.tex, false)
+		want := `<merror title="` + tt.reason + `"><mtext>`
+		if !strings.Contains(got, want) {
+			t.Errorf("texToMathML(%q) = %s, want an merror titled %q", tt.tex, got, tt.reason)
+		}
+	}
+}
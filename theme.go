@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// theme holds the colours mdview's own additions use to match one of the
+// built-in stylesheets.
+type theme struct {
+	name       string
+	alerts     map[string]string // alert kind to accent colour
+	background string
+	border     string
+	highlight  string   // background of ==highlighted== text
+	palette    []string // chart colours
+}
+
+var lightTheme = &theme{
+	name: "light",
+	alerts: map[string]string{
+		"note":      "#0969da",
+		"tip":       "#1a7f37",
+		"important": "#8250df",
+		"warning":   "#9a6700",
+		"caution":   "#cf222e",
+	},
+	background: "#fff",
+	border:     "#d0d7de",
+	highlight:  "#fff8c5",
+	palette:    []string{"#0969da", "#1a7f37", "#cf222e", "#8250df", "#9a6700", "#bf3989", "#1b7c83", "#bc4c00"},
+}
+
+var darkTheme = &theme{
+	name: "dark",
+	alerts: map[string]string{
+		"note":      "#4493f8",
+		"tip":       "#3fb950",
+		"important": "#ab7df8",
+		"warning":   "#d29922",
+		"caution":   "#f85149",
+	},
+	background: "#282828",
+	border:     "#444c56",
+	highlight:  "rgba(187,128,9,.4)",
+	palette:    []string{"#4493f8", "#3fb950", "#f85149", "#ab7df8", "#d29922", "#db61a2", "#39c5cf", "#db6d28"},
+}
+
+// css returns the theme specific rules for mdview's additions.
+func (t *theme) css() string {
+	var b strings.Builder
+	b.WriteString(`.markdown-body .markdown-alert{padding:.5em 1em;margin-bottom:16px;color:inherit;border-left:.25em solid}
+	.markdown-body .markdown-alert>:last-child{margin-bottom:0}.markdown-body .markdown-alert-title{display:flex;align-items:center;
+	font-weight:600;line-height:1;margin-bottom:8px}.markdown-body .markdown-alert-title svg{margin-right:8px;width:16px;height:16px}`)
+	for _, kind := range alertKinds {
+		fmt.Fprintf(&b, ".markdown-body .markdown-alert-%s{border-left-color:%s}.markdown-body .markdown-alert-%s .markdown-alert-title{color:%s}",
+			kind, t.alerts[kind], kind, t.alerts[kind])
+	}
+	fmt.Fprintf(&b, `.markdown-body .footnote-popup{z-index:10;max-width:400px;padding:8px 12px;font-size:14px;line-height:1.5;
+	background-color:%s;border:1px solid %s;border-radius:6px;box-shadow:0 4px 12px rgba(0,0,0,.2)}.markdown-body .footnote-popup>:first-child{margin-top:0}
+	.markdown-body .footnote-popup>:last-child{margin-bottom:0}`, t.background, t.border)
+	fmt.Fprintf(&b, ".markdown-body merror{color:%s;border:1px dashed;background:none}", t.alerts["caution"])
+	fmt.Fprintf(&b, ".markdown-body .mdview-filter-error{color:%s;border-left:.25em solid;white-space:pre-wrap}", t.alerts["caution"])
+	fmt.Fprintf(&b, ".markdown-body .mdview-dangling-reference{color:%s}", t.alerts["caution"])
+	fmt.Fprintf(&b, ".markdown-body mark{background-color:%s;color:inherit}", t.highlight)
+	return b.String()
+}
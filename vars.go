@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// varFlag collects the key=value pairs of repeated -var flags.
+type varFlag []string
+
+func (v *varFlag) String() string { return strings.Join(*v, ",") }
+
+func (v *varFlag) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("%q is not key=value", s)
+	}
+	*v = append(*v, s)
+	return nil
+}
+
+// varEnvPrefix starts the names of environment variables that set
+// document variables, as MDVIEW_VAR_product sets product.
+const varEnvPrefix = "MDVIEW_VAR_"
+
+// variables returns the variables a document sees: those set by its front
+// matter, by MDVIEW_VAR_ environment variables and by -var, each taking
+// precedence over the ones before.
+func (r *renderer) variables(meta metadata) map[string]string {
+	vars := make(map[string]string)
+	for k, v := range meta {
+		switch v := v.(type) {
+		case string:
+			vars[k] = v
+		case []string:
+			vars[k] = strings.Join(v, ", ")
+		}
+	}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, varEnvPrefix) {
+			if eq := strings.IndexByte(kv, '='); eq > len(varEnvPrefix) {
+				vars[kv[len(varEnvPrefix):eq]] = kv[eq+1:]
+			}
+		}
+	}
+	for k, v := range r.vars {
+		vars[k] = v
+	}
+	return vars
+}
+
+var (
+	variable   = regexp.MustCompile(`\{\{\s*\.([\w-]+)\s*\}\}`)
+	directive  = regexp.MustCompile(`^\s*<!--\s*(if|elif|else if|else|endif)\b(.*?)-->\s*$`)
+	listMarker = regexp.MustCompile(`^(?:[-*+]|\d{1,9}[.)])(?:[ \t]+|$)`)
+)
+
+// preprocess replaces variables such as {{ .product }} in src with their
+// values, leaving those that are not set alone, and keeps or drops the
+// lines between conditional comments such as
+//
+//	<!-- if edition == "enterprise" -->
+//	<!-- elif edition != "community" -->
+//	<!-- else -->
+//	<!-- endif -->
+//
+// which must stand on lines of their own. Fenced and indented code blocks
+// and code spans are left as they are, so that they can show such syntax.
+// Dropped lines and the comments themselves are blanked, so that the
+// remaining lines keep their numbers. It returns the problems found along
+// with the result.
+func preprocess(src []byte, vars map[string]string) ([]byte, []string) {
+	if !bytes.Contains(src, []byte("{{")) && !bytes.Contains(src, []byte("<!--")) {
+		return src, nil
+	}
+	type branch struct {
+		line         int
+		active, done bool
+	}
+	var stack []branch
+	var problems []string
+	visible := func() bool {
+		for _, b := range stack {
+			if !b.active {
+				return false
+			}
+		}
+		return true
+	}
+	var cb codeBlocks
+	var out bytes.Buffer
+	for n, line := range strings.SplitAfter(string(src), "\n") {
+		if code := cb.next(line); code {
+			if visible() {
+				out.WriteString(line)
+			} else if strings.HasSuffix(line, "\n") {
+				out.WriteByte('\n')
+			}
+			continue
+		}
+		if m := directive.FindStringSubmatch(line); m != nil {
+			expr := strings.TrimSpace(m[2])
+			cond := func() bool {
+				ok, err := evalCondition(expr, vars)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%d: %v", n+1, err))
+				}
+				return ok
+			}
+			switch m[1] {
+			case "if":
+				ok := cond()
+				stack = append(stack, branch{n + 1, ok, ok})
+			case "elif", "else if", "else":
+				if len(stack) == 0 {
+					problems = append(problems, fmt.Sprintf("%d: %s without if", n+1, m[1]))
+					break
+				}
+				top := &stack[len(stack)-1]
+				if m[1] == "else" {
+					top.active = !top.done
+				} else {
+					top.active = !top.done && cond()
+				}
+				top.done = top.done || top.active
+			case "endif":
+				if len(stack) == 0 {
+					problems = append(problems, fmt.Sprintf("%d: endif without if", n+1))
+					break
+				}
+				stack = stack[:len(stack)-1]
+			}
+			if strings.HasSuffix(line, "\n") {
+				out.WriteByte('\n')
+			}
+			continue
+		}
+		if !visible() {
+			if strings.HasSuffix(line, "\n") {
+				out.WriteByte('\n')
+			}
+			continue
+		}
+		out.WriteString(substituteText(line, vars))
+	}
+	for _, b := range stack {
+		problems = append(problems, fmt.Sprintf("%d: if without endif", b.line))
+	}
+	return out.Bytes(), problems
+}
+
+// codeBlocks follows a document line by line to tell which lines belong to
+// fenced or indented code blocks. It knows enough about lists not to take
+// their indented paragraphs for code.
+type codeBlocks struct {
+	fence    string // the opening run of ` or ~ of the current fence
+	started  bool   // a line has been seen
+	blank    bool   // the previous line was blank
+	indented bool   // the previous line was in an indented code block
+	list     int    // the content indent of the current list item, if any
+}
+
+// next reports whether line is part of a code block, fence lines
+// included.
+func (c *codeBlocks) next(line string) bool {
+	indent, rest := leadingIndent(strings.TrimRight(line, "\r\n"))
+	afterBlank := c.blank || !c.started
+	c.started, c.blank = true, rest == ""
+	if c.fence != "" {
+		if indent < c.list+4 && strings.HasPrefix(rest, c.fence) && strings.TrimSpace(strings.TrimLeft(rest, c.fence[:1])) == "" {
+			c.fence = ""
+		}
+		return true
+	}
+	if rest == "" {
+		return c.indented
+	}
+	if indent >= c.list+4 && (afterBlank || c.indented) {
+		c.indented = true
+		return true
+	}
+	c.indented = false
+	if run := fenceRun(rest); run != "" {
+		c.fence = run
+		return true
+	}
+	if m := listMarker.FindString(rest); m != "" {
+		width := len(m)
+		if marker := len(strings.TrimRight(m, " \t")); width == marker || width-marker > 4 {
+			width = marker + 1 // the item is empty or starts with an indented code block
+		}
+		c.list = indent + width
+	} else if afterBlank && indent < c.list {
+		c.list = 0
+	}
+	return false
+}
+
+// leadingIndent returns the width of the indentation of line, counting tabs
+// to the next multiple of 4, and the rest of it.
+func leadingIndent(line string) (int, string) {
+	width := 0
+	for i, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4 - width%4
+		default:
+			return width, line[i:]
+		}
+	}
+	return width, ""
+}
+
+// fenceRun returns the run of three or more backticks or tildes that opens
+// a fenced code block at the start of s, if there is one.
+func fenceRun(s string) string {
+	if !strings.HasPrefix(s, "```") && !strings.HasPrefix(s, "~~~") {
+		return ""
+	}
+	run := s[:len(s)-len(strings.TrimLeft(s, s[:1]))]
+	if run[0] == '`' && strings.Contains(s[len(run):], "`") {
+		return "" // backticks in the info string make it a code span
+	}
+	return run
+}
+
+// substituteText replaces the variables in line that are set with their
+// values, except inside code spans.
+func substituteText(line string, vars map[string]string) string {
+	if !strings.Contains(line, "`") {
+		return substitute(line, vars)
+	}
+	var b strings.Builder
+	for {
+		open := strings.IndexByte(line, '`')
+		if open < 0 {
+			break
+		}
+		n := len(line[open:]) - len(strings.TrimLeft(line[open:], "`"))
+		ticks := line[open : open+n]
+		end := -1
+		for i := open + n; i < len(line); {
+			j := strings.Index(line[i:], ticks)
+			if j < 0 {
+				break
+			}
+			j += i
+			if k := j + n; k == len(line) || line[k] != '`' {
+				end = k
+				break
+			}
+			i = j + n + len(line[j+n:]) - len(strings.TrimLeft(line[j+n:], "`"))
+		}
+		if end < 0 {
+			// An unmatched run of backticks is literal text.
+			b.WriteString(substitute(line[:open+n], vars))
+			line = line[open+n:]
+			continue
+		}
+		b.WriteString(substitute(line[:open], vars))
+		b.WriteString(line[open:end])
+		line = line[end:]
+	}
+	b.WriteString(substitute(line, vars))
+	return b.String()
+}
+
+// substitute replaces the variables in s that are set with their values.
+func substitute(s string, vars map[string]string) string {
+	return variable.ReplaceAllStringFunc(s, func(v string) string {
+		if value, ok := vars[variable.FindStringSubmatch(v)[1]]; ok {
+			return value
+		}
+		return v
+	})
+}
+
+var conditionToken = regexp.MustCompile(`^\s*("(?:[^"\\]|\\.)*"|'[^']*'|==|!=|&&|\|\||[!()]|[\w.-]+)`)
+
+// evalCondition evaluates the condition of an if or elif comment: names
+// of variables, with or without a leading dot, and quoted strings,
+// compared with == and !=, combined with and, or and not (or &&, || and
+// !) and grouped with parentheses. A variable on its own is true unless it
+// is unset, empty, "false", "no" or "0".
+func evalCondition(expr string, vars map[string]string) (bool, error) {
+	var toks []string
+	for s := expr; strings.TrimSpace(s) != ""; {
+		m := conditionToken.FindStringSubmatch(s)
+		if m == nil {
+			return false, fmt.Errorf("bad condition %q", expr)
+		}
+		toks = append(toks, m[1])
+		s = s[len(m[0]):]
+	}
+	p := &condParser{toks: toks, vars: vars}
+	v, err := p.or()
+	if err == nil && p.pos < len(toks) {
+		err = fmt.Errorf("unexpected %q", toks[p.pos])
+	}
+	if err != nil {
+		return false, fmt.Errorf("condition %q: %v", expr, err)
+	}
+	return v, nil
+}
+
+type condParser struct {
+	toks []string
+	pos  int
+	vars map[string]string
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) or() (bool, error) {
+	v, err := p.and()
+	for err == nil && (p.peek() == "or" || p.peek() == "||") {
+		p.pos++
+		var w bool
+		w, err = p.and()
+		v = v || w
+	}
+	return v, err
+}
+
+func (p *condParser) and() (bool, error) {
+	v, err := p.unary()
+	for err == nil && (p.peek() == "and" || p.peek() == "&&") {
+		p.pos++
+		var w bool
+		w, err = p.unary()
+		v = v && w
+	}
+	return v, err
+}
+
+func (p *condParser) unary() (bool, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.pos++
+		v, err := p.unary()
+		return !v, err
+	case "(":
+		p.pos++
+		v, err := p.or()
+		if err == nil && p.peek() != ")" {
+			err = fmt.Errorf("missing )")
+		}
+		p.pos++
+		return v, err
+	}
+	left, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+	switch op := p.peek(); op {
+	case "==", "!=":
+		p.pos++
+		right, err := p.operand()
+		return (left == right) == (op == "=="), err
+	}
+	switch strings.ToLower(left) {
+	case "", "false", "no", "0":
+		return false, nil
+	}
+	return true, nil
+}
+
+// operand returns the value of a quoted string or variable.
+func (p *condParser) operand() (string, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "":
+		return "", fmt.Errorf("unexpected end")
+	case tok[0] == '"':
+		return strconv.Unquote(tok)
+	case tok[0] == '\'':
+		return tok[1 : len(tok)-1], nil
+	case strings.ContainsAny(tok, "()!=&|"):
+		return "", fmt.Errorf("unexpected %q", tok)
+	}
+	return p.vars[strings.TrimPrefix(tok, ".")], nil
+}
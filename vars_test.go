@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvalCondition(t *testing.T) {
+	vars := map[string]string{"edition": "enterprise", "beta": "false", "os": "linux", "count": "0", "quoted": `say "hi"`}
+	tests := []struct {
+		expr string
+		want bool
+		err  string
+	}{
+		{expr: `edition == "enterprise"`, want: true},
+		{expr: `.edition == 'enterprise'`, want: true},
+		{expr: `edition != "enterprise"`},
+		{expr: `edition == os`},
+		{expr: `missing == ""`, want: true},
+		{expr: `edition`, want: true},
+		{expr: `beta`},
+		{expr: `count`},
+		{expr: `missing`},
+		{expr: `not beta`, want: true},
+		{expr: `!edition`},
+		{expr: `edition and beta`},
+		{expr: `edition && os == "linux"`, want: true},
+		{expr: `beta or os == "linux"`, want: true},
+		{expr: `beta || missing`},
+		{expr: `not (beta or missing)`, want: true},
+		{expr: `edition == "community" or edition == "enterprise" and os == "linux"`, want: true},
+		{expr: `(edition == "community" or edition == "enterprise") and os == "mac"`},
+		{expr: `quoted == "say \"hi\""`, want: true},
+		{expr: ``, err: "unexpected end"},
+		{expr: `edition ==`, err: "unexpected end"},
+		{expr: `(edition`, err: "missing )"},
+		{expr: `edition edition`, err: `unexpected "edition"`},
+		{expr: `edition = "x"`, err: "bad condition"},
+		{expr: `== edition`, err: `unexpected "=="`},
+	}
+	for _, tt := range tests {
+		got, err := evalCondition(tt.expr, vars)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("evalCondition(%q) error = %v, want %q", tt.expr, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("evalCondition(%q): %v", tt.expr, err)
+		case got != tt.want:
+			t.Errorf("evalCondition(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	vars := map[string]string{"product": "Widget", "edition": "enterprise"}
+	tests := []struct {
+		name, src, want string
+		problems        []string
+	}{{
+		name: "variables",
+		src:  "Install {{ .product }} and {{.product}}, not {{ .unset }} or {{ product }}.\n",
+		want: "Install Widget and Widget, not {{ .unset }} or {{ product }}.\n",
+	}, {
+		name: "if",
+		src:  "a\n<!-- if edition == \"enterprise\" -->\nb\n<!-- endif -->\nc\n",
+		want: "a\n\nb\n\nc\n",
+	}, {
+		name: "else",
+		src:  "<!-- if edition == \"community\" -->\nb\n<!-- else -->\nc\n<!-- endif -->\n",
+		want: "\n\n\nc\n\n",
+	}, {
+		name: "elif",
+		src:  "<!-- if beta -->\na\n<!-- elif edition -->\nb\n<!-- else if product -->\nc\n<!-- else -->\nd\n<!-- endif -->",
+		want: "\n\n\nb\n\n\n\n\n",
+	}, {
+		name: "nested",
+		src:  "<!-- if beta -->\n<!-- if edition -->\na\n<!-- endif -->\nb\n<!-- endif -->\nc\n",
+		want: "\n\n\n\n\n\nc\n",
+	}, {
+		name: "fences",
+		src:  "```md\n{{ .product }}\n<!-- if beta -->\n```\n{{ .product }}\n",
+		want: "```md\n{{ .product }}\n<!-- if beta -->\n```\nWidget\n",
+	}, {
+		name: "longer fences",
+		src:  "````md\n```\n{{ .product }}\n```\n````\n{{ .product }}\n",
+		want: "````md\n```\n{{ .product }}\n```\n````\nWidget\n",
+	}, {
+		name: "fence closed only by a bare run",
+		src:  "~~~\n~~~ not a close\n{{ .product }}\n```\n~~~~  \n{{ .product }}\n",
+		want: "~~~\n~~~ not a close\n{{ .product }}\n```\n~~~~  \nWidget\n",
+	}, {
+		name: "indented code",
+		src:  "text\n\n    {{ .product }}\n\n\t<!-- if beta -->\n\nWidget {{ .product }}\n",
+		want: "text\n\n    {{ .product }}\n\n\t<!-- if beta -->\n\nWidget Widget\n",
+	}, {
+		name: "indented continuation",
+		src:  "text\n    {{ .product }}\n",
+		want: "text\n    Widget\n",
+	}, {
+		name: "list paragraphs",
+		src:  "- item\n\n    {{ .product }}\n\n1.  {{ .product }}\n\n        {{ .product }}\n\n-\n\n      {{ .product }}\n",
+		want: "- item\n\n    Widget\n\n1.  Widget\n\n        {{ .product }}\n\n-\n\n      {{ .product }}\n",
+	}, {
+		name: "after a list",
+		src:  "- item\n\ntext\n\n    {{ .product }}\n",
+		want: "- item\n\ntext\n\n    {{ .product }}\n",
+	}, {
+		name: "code spans",
+		src:  "`{{ .product }}` {{ .product }} ``a ` {{ .product }}`` `` ` {{ .product }}\n",
+		want: "`{{ .product }}` Widget ``a ` {{ .product }}`` `` ` Widget\n",
+	}, {
+		name: "hidden variables",
+		src:  "<!-- if beta -->\n{{ .product }}\n<!-- endif -->\n",
+		want: "\n\n\n",
+	}, {
+		name:     "unbalanced",
+		src:      "<!-- endif -->\n<!-- else -->\n<!-- if edition -->\na\n",
+		want:     "\n\n\na\n",
+		problems: []string{"1: endif without if", "2: else without if", "3: if without endif"},
+	}, {
+		name:     "bad condition",
+		src:      "<!-- if edition = \"x\" -->\na\n<!-- endif -->\n",
+		want:     "\n\n\n",
+		problems: []string{`1: bad condition "edition = \"x\""`},
+	}}
+	for _, tt := range tests {
+		got, problems := preprocess([]byte(tt.src), vars)
+		if string(got) != tt.want {
+			t.Errorf("%s: preprocess = %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(problems, tt.problems) {
+			t.Errorf("%s: problems = %q, want %q", tt.name, problems, tt.problems)
+		}
+		if strings.Count(string(got), "\n") != strings.Count(tt.src, "\n") {
+			t.Errorf("%s: preprocess changed the number of lines", tt.name)
+		}
+	}
+}
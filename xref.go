@@ -0,0 +1,233 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// addTarget records the text cross-references to the element id show.
+func (d *document) addTarget(id, text string) {
+	if d.targets == nil {
+		d.targets = make(map[string]string)
+	}
+	d.targets[id] = text
+}
+
+// sectionNumbers numbers the headings of level from and below
+// hierarchically, as in "3.2.1 Error handling", and makes references to
+// them read "Section 3.2.1". With from set to 0 headings are not numbered
+// and references show their text. Headings with the class "unnumbered"
+// are left out.
+func (d *document) sectionNumbers(from int) {
+	var counts [6]int
+	for i, tok := range d.tokens {
+		open, ok := tok.(*markdown.HeadingOpen)
+		if !ok || i+1 >= len(d.tokens) {
+			continue
+		}
+		inline, ok := d.tokens[i+1].(*markdown.Inline)
+		if !ok {
+			continue
+		}
+		id := d.attr(tok, "id")
+		if from <= 0 || open.HLevel < from || hasClass(d.attr(tok, "class"), "unnumbered") {
+			if open.HLevel < from {
+				counts = [6]int{}
+			}
+			d.addTarget(id, strings.TrimSpace(getText(inline)))
+			continue
+		}
+
+		level := open.HLevel - from
+		counts[level]++
+		for j := level + 1; j < len(counts); j++ {
+			counts[j] = 0
+		}
+		parts := make([]string, level+1)
+		for j := range parts {
+			parts[j] = strconv.Itoa(counts[j])
+		}
+		number := strings.Join(parts, ".")
+		inline.Children = append([]markdown.Token{
+			&markdown.HTMLInline{Content: `<span class="section-number">` + number + "</span> "},
+		}, inline.Children...)
+		d.addTarget(id, "Section "+number)
+	}
+}
+
+// attr returns the value of the attribute name set on tok.
+func (d *document) attr(tok markdown.Token, name string) string {
+	for _, a := range d.attrs[tok] {
+		if a.name == name {
+			return a.value
+		}
+	}
+	return ""
+}
+
+func hasClass(classes, class string) bool {
+	for _, c := range strings.Fields(classes) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// tableCaptions turns a paragraph starting with "Table:" right after a
+// table into the table's caption, numbered "Table 1", "Table 2" and so on
+// if number is set. An attribute block at its end, as in
+// "Table: Exit codes {#tbl:exit}", sets the table's id and classes.
+func (d *document) tableCaptions(number bool) {
+	n := 0
+	for i := 0; i+3 < len(d.tokens); i++ {
+		if _, ok := d.tokens[i].(*markdown.TableClose); !ok {
+			continue
+		}
+		if _, ok := d.tokens[i+1].(*markdown.ParagraphOpen); !ok {
+			continue
+		}
+		inline, ok := d.tokens[i+2].(*markdown.Inline)
+		if !ok || !strings.HasPrefix(inline.Content, "Table:") || len(inline.Children) == 0 {
+			continue
+		}
+		first, ok := inline.Children[0].(*markdown.Text)
+		if !ok || !strings.HasPrefix(first.Content, "Table:") {
+			continue
+		}
+		first.Content = strings.TrimLeft(first.Content[len("Table:"):], " ")
+
+		open := i
+		for ; open >= 0; open-- {
+			if _, ok := d.tokens[open].(*markdown.TableOpen); ok {
+				break
+			}
+		}
+		if open < 0 {
+			continue
+		}
+		table := d.tokens[open]
+		for _, a := range trailingAttributes(inline) {
+			d.setAttr(table, a.name, a.value)
+		}
+
+		n++
+		caption := strings.TrimSpace(getText(inline))
+		prefix := ""
+		if number {
+			label := "Table " + strconv.Itoa(n)
+			if d.attr(table, "id") == "" {
+				d.setAttr(table, "id", "table-"+strconv.Itoa(n))
+			}
+			d.addTarget(d.attr(table, "id"), label)
+			prefix = `<span class="table-number">` + label + ":</span> "
+		} else if id := d.attr(table, "id"); id != "" {
+			d.addTarget(id, caption)
+		}
+
+		// Move the paragraph's inline content into a caption element at
+		// the start of the table.
+		d.tokens = append(d.tokens[:i+1], d.tokens[i+4:]...)
+		d.insert(open+1,
+			&markdown.HTMLBlock{Content: "<caption>" + prefix},
+			inline,
+			&markdown.HTMLBlock{Content: "</caption>\n"})
+		i += 3
+	}
+}
+
+var crossReference = regexp.MustCompile(`@(sec|fig|tbl):([\w-]+(?:[.:][\w-]+)*)`)
+
+// crossReferences links references such as @sec:errors, @fig:arch and
+// @tbl:exit to the section, figure or table with that id, which may be
+// written with the prefix, as in {#fig:arch} or {#fig-arch}, or without
+// it, and fills in the text of empty links such as [](#errors).
+// It returns the references that lead nowhere.
+func (d *document) crossReferences() []string {
+	var dangling []string
+	for _, tok := range d.tokens {
+		inline, ok := tok.(*markdown.Inline)
+		if !ok {
+			continue
+		}
+		var children []markdown.Token
+		for j := 0; j < len(inline.Children); j++ {
+			switch child := inline.Children[j].(type) {
+			case *markdown.Text:
+				toks, missing := d.expandReferences(child)
+				children = append(children, toks...)
+				dangling = append(dangling, missing...)
+				continue
+			case *markdown.LinkOpen:
+				if j+1 >= len(inline.Children) || !strings.HasPrefix(child.Href, "#") {
+					break
+				}
+				if _, ok := inline.Children[j+1].(*markdown.LinkClose); !ok {
+					break
+				}
+				text, ok := d.targets[child.Href[1:]]
+				if !ok {
+					dangling = append(dangling, "[]("+child.Href+")")
+					break
+				}
+				children = append(children, child, &markdown.Text{Content: text, Lvl: child.Lvl + 1})
+				continue
+			}
+			children = append(children, inline.Children[j])
+		}
+		inline.Children = children
+	}
+	return dangling
+}
+
+// expandReferences splits text around its @-references, returning the
+// tokens and the references that could not be resolved.
+func (d *document) expandReferences(text *markdown.Text) ([]markdown.Token, []string) {
+	s := text.Content
+	if !strings.Contains(s, "@") {
+		return []markdown.Token{text}, nil
+	}
+	var toks []markdown.Token
+	var dangling []string
+	last := 0
+	for _, m := range crossReference.FindAllStringSubmatchIndex(s, -1) {
+		// Leave e-mail addresses alone.
+		if r, _ := utf8.DecodeLastRuneInString(s[:m[0]]); m[0] > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			continue
+		}
+		ref := s[m[0]:m[1]]
+		kind, label := s[m[2]:m[3]], s[m[4]:m[5]]
+		var id, content string
+		ok := false
+		for _, id = range []string{kind + ":" + label, kind + "-" + label, label} {
+			if content, ok = d.targets[id]; ok {
+				break
+			}
+		}
+		if m[0] > last {
+			toks = append(toks, &markdown.Text{Content: s[last:m[0]], Lvl: text.Lvl})
+		}
+		if ok {
+			toks = append(toks, &markdown.HTMLInline{Content: `<a href="#` + html.EscapeString(id) + `" class="cross-reference">` +
+				html.EscapeString(content) + "</a>"})
+		} else {
+			dangling = append(dangling, ref)
+			toks = append(toks, &markdown.HTMLInline{Content: `<span class="mdview-dangling-reference">` + html.EscapeString(ref) + "</span>"})
+		}
+		last = m[1]
+	}
+	if last == 0 {
+		return []markdown.Token{text}, nil
+	}
+	if last < len(s) {
+		text.Content = s[last:]
+		toks = append(toks, text)
+	}
+	return toks, dangling
+}